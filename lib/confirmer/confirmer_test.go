@@ -0,0 +1,187 @@
+package confirmer
+
+import (
+	. "github.com/iotaledger/iota.go/api"
+	. "github.com/iotaledger/iota.go/bundle"
+	. "github.com/iotaledger/iota.go/trinary"
+	"github.com/op/go-logging"
+	"github.com/unioproject/tanglebeat/lib/mockiri"
+	"github.com/unioproject/tanglebeat/lib/multiapi"
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tests of Confirmer against in-process IRI mock. Each takes tens of seconds because of
+// polling periods of the confirmer and confirmation monitor
+
+var (
+	addr4test = Hash(strings.Repeat("A", 81))
+	tag4test  = Trytes("TANGLEBEAT9CONFIRMER9TEST99")
+)
+
+func newConfirmer4test(t *testing.T, m *mockiri.MockIRI) *Confirmer {
+	mapi, err := multiapi.New([]string{m.URL()}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewConfirmer(ConfirmerParams{
+		IotaMultiAPI:          mapi,
+		IotaMultiAPIgTTA:      mapi,
+		IotaMultiAPIaTT:       mapi,
+		TxTagPromote:          tag4test,
+		AddressPromote:        addr4test,
+		ForceReattachAfterMin: 10,
+		PromoteEverySec:       2,
+		Log:                   logging.MustGetLogger("confirmer_test"),
+	}, nil)
+}
+
+// prepares, attaches and stores zero value bundle
+func bundle4test(t *testing.T, conf *Confirmer) []Trytes {
+	ts := utils.UnixSec(time.Now())
+	prep, err := conf.IotaMultiAPI.GetAPI().PrepareTransfers(all9, Transfers{{
+		Address: addr4test,
+		Value:   0,
+		Tag:     tag4test,
+	}}, PrepareTransfersOptions{Timestamp: &ts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gtta, err := conf.IotaMultiAPIgTTA.GetTransactionsToApprove(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached, err := conf.IotaMultiAPIaTT.AttachToTangle(gtta.TrunkTransaction, gtta.BranchTransaction, uint64(14), prep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conf.IotaMultiAPI.StoreAndBroadcast(attached); err != nil {
+		t.Fatal(err)
+	}
+	return attached
+}
+
+// returns updates received before confirmation or timeout
+func waitConfirmation(t *testing.T, chUpdate chan *ConfirmerUpdate, timeout time.Duration) []*ConfirmerUpdate {
+	ret := make([]*ConfirmerUpdate, 0)
+	chTimeout := time.After(timeout)
+	for {
+		select {
+		case upd := <-chUpdate:
+			ret = append(ret, upd)
+			if upd.UpdateType == UPD_CONFIRM {
+				return ret
+			}
+		case <-chTimeout:
+			t.Fatalf("bundle wasn't confirmed in %v", timeout)
+			return ret
+		}
+	}
+}
+
+func countUpdates(updates []*ConfirmerUpdate, updType UpdateType) int {
+	var ret int
+	for _, upd := range updates {
+		if upd.UpdateType == updType {
+			ret++
+		}
+	}
+	return ret
+}
+
+func Test_ConfirmerPromoteAndConfirm(t *testing.T) {
+	m := mockiri.New()
+	defer m.Close()
+
+	conf := newConfirmer4test(t, m)
+	bundleTrytes := bundle4test(t, conf)
+	tail, err := utils.TailFromBundleTrytes(bundleTrytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chUpdate, cancel, err := conf.StartConfirmerTask(bundleTrytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if _, _, err = conf.StartConfirmerTask(bundleTrytes); err == nil {
+		t.Errorf("second task must not be started while the first is running")
+	}
+	// let it promote for a while, then confirm
+	time.Sleep(5 * time.Second)
+	m.ConfirmBundle(tail.Bundle)
+
+	updates := waitConfirmation(t, chUpdate, 30*time.Second)
+	if countUpdates(updates, UPD_PROMOTE) == 0 {
+		t.Errorf("expected promotions")
+	}
+	if countUpdates(updates, UPD_REATTACH) != 0 || m.NumAttachments(tail.Bundle) != 1 {
+		t.Errorf("consistent bundle must not be reattached")
+	}
+	for _, upd := range updates {
+		if upd.UpdateType == UPD_PROMOTE && upd.PromoteTailHash == "" {
+			t.Errorf("promotion update must contain tail hash")
+		}
+	}
+}
+
+func Test_ConfirmerReattachInconsistent(t *testing.T) {
+	m := mockiri.New()
+	defer m.Close()
+	// bundle will be confirmed upon first reattachment
+	m.SetConfirmationSchedule(2, 0)
+
+	conf := newConfirmer4test(t, m)
+	conf.PromoteDisable = true
+	bundleTrytes := bundle4test(t, conf)
+	tail, err := utils.TailFromBundleTrytes(bundleTrytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetInconsistent(tail.Hash, true)
+
+	chUpdate, cancel, err := conf.StartConfirmerTask(bundleTrytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	updates := waitConfirmation(t, chUpdate, 40*time.Second)
+	if countUpdates(updates, UPD_REATTACH) == 0 || m.NumAttachments(tail.Bundle) < 2 {
+		t.Errorf("inconsistent bundle must be reattached")
+	}
+	if countUpdates(updates, UPD_PROMOTE) != 0 {
+		t.Errorf("promotion is disabled")
+	}
+	last := updates[len(updates)-1]
+	if last.NumAttaches == 0 {
+		t.Errorf("number of attachments must be reported")
+	}
+}
+
+func Test_ConfirmerApiError(t *testing.T) {
+	m := mockiri.New()
+	defer m.Close()
+
+	conf := newConfirmer4test(t, m)
+	bundleTrytes := bundle4test(t, conf)
+	m.SetFailure("attachToTangle", "PoW failed")
+
+	chUpdate, cancel, err := conf.StartConfirmerTask(bundleTrytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	select {
+	case upd := <-chUpdate:
+		if upd.UpdateType != UPD_NO_ACTION || upd.Err == nil {
+			t.Errorf("expected error update, got '%v' err = %v", upd.UpdateType.ToString(), upd.Err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("expected error update")
+	}
+}
@@ -15,6 +15,8 @@ type ExpiringSegment interface {
 	Put(data ...interface{})
 	Touch()
 	Size() int
+	GetTimes() (uint64, uint64)
+	SetTimes(created, lastTouch uint64)
 }
 
 //-------------------------------
@@ -60,6 +62,13 @@ func (buf *ExpiringBuffer) GetID() string {
 	return buf.id
 }
 
+// new retention period takes effect with the next purge
+func (buf *ExpiringBuffer) SetRetentionPeriodSec(retentionPeriodSec int) {
+	buf.Lock()
+	defer buf.Unlock()
+	buf.retentionPeriodMs = uint64(retentionPeriodSec * 1000)
+}
+
 const purgeLoopSleepSec = 5
 
 // ---------------------- THREAD SAFE
@@ -128,6 +137,33 @@ func (buf *ExpiringBuffer) NewEntry(data ...interface{}) {
 	buf.top.Touch()
 }
 
+// RestoreSegments__ replaces content of the buffer with segments, ordered newest first.
+// Used to restore buffer from the snapshot. Expired segments are discarded
+// Returns number of segments restored
+func (buf *ExpiringBuffer) RestoreSegments__(segs []ExpiringSegment) int {
+	wasEmpty := buf.isEmpty()
+	var top, last ExpiringSegment
+	var ret int
+	for _, s := range segs {
+		if s.IsExpired(buf.retentionPeriodMs) {
+			break // older are expired too
+		}
+		s.SetPrev(nil)
+		if top == nil {
+			top = s
+		} else {
+			last.SetPrev(s)
+		}
+		last = s
+		ret++
+	}
+	buf.top = top
+	if wasEmpty && !buf.isEmpty() {
+		go buf.purgeLoop()
+	}
+	return ret
+}
+
 func (buf *ExpiringBuffer) ForEachSegment__(callback func(seg ExpiringSegment) bool) {
 	for s := buf.top; s != nil; s = s.GetPrev() {
 		if !s.IsExpired(buf.retentionPeriodMs) {
@@ -175,6 +211,16 @@ func (seg *ExpiringSegmentBase) Touch() {
 	seg.lastTouch = utils.UnixMsNow()
 }
 
+// returns created, lastTouch
+func (seg *ExpiringSegmentBase) GetTimes() (uint64, uint64) {
+	return seg.created, seg.lastTouch
+}
+
+func (seg *ExpiringSegmentBase) SetTimes(created, lastTouch uint64) {
+	seg.created = created
+	seg.lastTouch = lastTouch
+}
+
 func (seg *ExpiringSegmentBase) IsOpen_(segDurationMs uint64) bool {
 	return utils.UnixMsNow()-seg.created < segDurationMs
 }
@@ -1,6 +1,7 @@
 package ebuffer
 
 import (
+	"github.com/unioproject/tanglebeat/lib/utils"
 	"math/rand"
 	"sync"
 	"testing"
@@ -69,3 +70,46 @@ func countLoop(id int, b *EventTsExpiringBuffer, wg *sync.WaitGroup) {
 		time.Sleep(2 * time.Second)
 	}
 }
+
+func Test_RestoreSegments(t *testing.T) {
+	SetLog(nil, true)
+	retentionPeriodSec := 60
+	buf := NewEventTsExpiringBuffer("testRestoreSegments", 10, retentionPeriodSec)
+
+	nowis := utils.UnixMsNow()
+	segs := make([]ExpiringSegment, 0, 3)
+	// newest first. The last one is expired
+	for i, ageSec := range []uint64{1, 30, uint64(retentionPeriodSec) + 1} {
+		seg := NewEventTSExpiringSegment(0)
+		for j := 0; j <= i; j++ {
+			seg.Put(nowis)
+		}
+		seg.SetTimes(nowis-ageSec*1000, nowis-ageSec*1000)
+		segs = append(segs, seg)
+	}
+	buf.Lock()
+	n := buf.RestoreSegments__(segs)
+	buf.Unlock()
+	if n != 2 {
+		t.Errorf("expected 2 segments restored, got %v", n)
+	}
+	nums, nume := buf.Size()
+	if nums != 2 || nume != 3 {
+		t.Errorf("expected 2 segments and 3 entries, got %v and %v", nums, nume)
+	}
+	if c, _ := buf.CountAll(); c != 3 {
+		t.Errorf("expected CountAll = 3, got %v", c)
+	}
+	// new entries go to the restored top segment, it is still open
+	buf.RecordTS()
+	if nums, nume = buf.Size(); nums != 2 || nume != 4 {
+		t.Errorf("expected 2 segments and 4 entries after insert, got %v and %v", nums, nume)
+	}
+
+	buf.Lock()
+	n = buf.RestoreSegments__(nil)
+	buf.Unlock()
+	if nums, nume = buf.Size(); n != 0 || nums != 0 || nume != 0 {
+		t.Errorf("expected empty buffer, got %v segments and %v entries", nums, nume)
+	}
+}
@@ -0,0 +1,42 @@
+package irisim
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of the simulator: timestamps in messages, delays of nodes and milestone intervals.
+// Default is the system clock. With ManualClock the simulator runs only when moved forward by Simulator.Advance,
+// so the simulation is deterministic and doesn't depend on the speed of the machine
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type ManualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) add(d time.Duration) time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
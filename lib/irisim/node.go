@@ -0,0 +1,134 @@
+package irisim
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// simulated node delivers messages to its sink each with its own delay.
+// Messages are kept in the heap ordered by due time, so with jitter they may
+// come out of order, the same way it happens with real nodes
+
+const nodeLoopPeriod = 10 * time.Millisecond
+
+type scheduledMsg struct {
+	due time.Time
+	seq uint64
+	msg []byte
+}
+
+type msgQueue []*scheduledMsg
+
+func (q msgQueue) Len() int { return len(q) }
+func (q msgQueue) Less(i, j int) bool {
+	if q[i].due.Equal(q[j].due) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].due.Before(q[j].due)
+}
+func (q msgQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *msgQueue) Push(x interface{}) { *q = append(*q, x.(*scheduledMsg)) }
+func (q *msgQueue) Pop() interface{} {
+	old := *q
+	ret := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ret
+}
+
+type simNode struct {
+	id      int
+	uri     string
+	params  NodeParams
+	sink    MsgSink
+	mutex   sync.Mutex
+	queue   msgQueue
+	seq     uint64
+	sent    uint64
+	dropped uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type NodeStats struct {
+	Uri     string
+	Sent    uint64
+	Dropped uint64
+	Queued  int
+}
+
+func newSimNode(id int, uri string, params NodeParams, sink MsgSink) *simNode {
+	return &simNode{
+		id:     id,
+		uri:    uri,
+		params: params,
+		sink:   sink,
+		queue:  make(msgQueue, 0),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (n *simNode) schedule(msg []byte, due time.Time) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.seq++
+	heap.Push(&n.queue, &scheduledMsg{due: due, seq: n.seq, msg: msg})
+}
+
+func (n *simNode) countDropped() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.dropped++
+}
+
+func (n *simNode) getStats() NodeStats {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return NodeStats{
+		Uri:     n.uri,
+		Sent:    n.sent,
+		Dropped: n.dropped,
+		Queued:  len(n.queue),
+	}
+}
+
+func (n *simNode) popDue(now time.Time) []*scheduledMsg {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	var ret []*scheduledMsg
+	for len(n.queue) > 0 && !n.queue[0].due.After(now) {
+		ret = append(ret, heap.Pop(&n.queue).(*scheduledMsg))
+	}
+	n.sent += uint64(len(ret))
+	return ret
+}
+
+func (n *simNode) run(clock Clock) {
+	defer close(n.done)
+	ticker := time.NewTicker(nodeLoopPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			n.sink.Close()
+			return
+		case <-ticker.C:
+			n.deliver(clock.Now())
+		}
+	}
+}
+
+// sends messages which are due by 'now' to the sink
+func (n *simNode) deliver(now time.Time) {
+	for _, m := range n.popDue(now) {
+		if err := n.sink.Send(m.msg); err != nil {
+			errorf("Simulated node %v (%v): %v", n.id, n.uri, err)
+		}
+	}
+}
+
+func (n *simNode) close() {
+	close(n.stop)
+	<-n.done
+}
@@ -0,0 +1,419 @@
+package irisim
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Simulator of IRI nodes for local testing of Tanglebeat.
+// It generates synthetic tangle: transactions, bundles and milestones confirming part of them.
+// Each message is published by every simulated node in the exact format of IRI ZMQ
+// ('tx', 'sn', 'lmi' and 'lmhs' topics), each node with its own lag, message drop rate
+// and, optionally, being out of sync (lagging behind with milestones).
+
+const (
+	generatorLoopPeriod         = 100 * time.Millisecond
+	defaultMilestoneIntervalSec = 60
+	defaultStartMilestoneIndex  = 1000000
+	defaultHost                 = "127.0.0.1"
+	tryteAlphabet               = "9ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	hashLen                     = 81
+	tagLen                      = 27
+)
+
+type NodeParams struct {
+	LagMs               int     // constant delay of all messages of the node
+	JitterMs            int     // random delay from 0 to JitterMs added to each message
+	DropRate            float64 // probability for each message to be lost, 0 to 1
+	OutOfSyncMilestones int     // milestone related messages (lmi, lmhs, sn) are delayed by that many milestone intervals
+}
+
+type Params struct {
+	Protocol             string       // "zmq" (default) or "nanomsg". Ignored if sinks are provided
+	Host                 string       // host in URIs of nodes. Default is 127.0.0.1
+	BasePort             int          // node i listens on BasePort + i
+	Nodes                []NodeParams // one entry for each simulated node
+	TPS                  float64      // transactions per second
+	ConfirmationRate     float64      // share of bundles to be confirmed by next milestone, 0 to 1
+	ValueBundleRate      float64      // share of value (transfer) bundles, 0 to 1
+	MilestoneIntervalSec float64      // default is 60
+	StartMilestoneIndex  int          // default is 1000000
+	Seed                 int64        // seed of random generator. 0 means time based
+	Clock                Clock        // default is the system clock. With ManualClock use Advance instead of Start
+}
+
+type Stats struct {
+	MilestoneIndex  int
+	NumTx           uint64
+	NumBundles      uint64
+	NumValueBundles uint64
+	NumConfirmedTx  uint64
+	Nodes           []NodeStats
+}
+
+type simTx struct {
+	hash    string
+	address string
+	bundle  string
+	trunk   string
+	branch  string
+}
+
+type Simulator struct {
+	params         Params
+	clock          Clock
+	nodes          []*simNode
+	rnd            *rand.Rand
+	milestoneIndex int
+	pending        []*simTx // to be confirmed by next milestone
+	stats          Stats
+	mutex          sync.RWMutex
+	started        bool
+	stop           chan struct{}
+	done           chan struct{}
+	// state of the generator, only accessed by generatorLoop or Advance
+	generating    bool
+	txAcc         float64
+	lastGen       time.Time
+	nextMilestone time.Time
+}
+
+// NewSimulator opens PUB sockets for all nodes according to params
+func NewSimulator(params Params) (*Simulator, error) {
+	if err := checkParams(&params); err != nil {
+		return nil, err
+	}
+	sinks := make([]MsgSink, 0, len(params.Nodes))
+	for i := range params.Nodes {
+		var sink MsgSink
+		var err error
+		switch params.Protocol {
+		case "zmq":
+			sink, err = newZmqSink(params.BasePort + i)
+		case "nanomsg":
+			sink, err = newNanomsgSink(params.BasePort + i)
+		}
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return newSimulator(params, sinks), nil
+}
+
+// NewSimulatorWithSinks creates simulator which sends messages of node i to sinks[i]
+func NewSimulatorWithSinks(params Params, sinks []MsgSink) (*Simulator, error) {
+	if err := checkParams(&params); err != nil {
+		return nil, err
+	}
+	if len(sinks) != len(params.Nodes) {
+		return nil, fmt.Errorf("irisim: %v sinks provided for %v nodes", len(sinks), len(params.Nodes))
+	}
+	return newSimulator(params, sinks), nil
+}
+
+func checkParams(params *Params) error {
+	if len(params.Nodes) == 0 {
+		return fmt.Errorf("irisim: at least one node must be simulated")
+	}
+	if params.Protocol == "" {
+		params.Protocol = "zmq"
+	}
+	if params.Protocol != "zmq" && params.Protocol != "nanomsg" {
+		return fmt.Errorf("irisim: unknown protocol '%v'", params.Protocol)
+	}
+	if params.Host == "" {
+		params.Host = defaultHost
+	}
+	if params.MilestoneIntervalSec <= 0 {
+		params.MilestoneIntervalSec = defaultMilestoneIntervalSec
+	}
+	if params.StartMilestoneIndex <= 0 {
+		params.StartMilestoneIndex = defaultStartMilestoneIndex
+	}
+	if params.Clock == nil {
+		params.Clock = systemClock{}
+	}
+	if params.Seed == 0 {
+		params.Seed = params.Clock.Now().UnixNano()
+	}
+	if params.TPS < 0 {
+		return fmt.Errorf("irisim: TPS can't be negative")
+	}
+	if params.ConfirmationRate < 0 || params.ConfirmationRate > 1 {
+		return fmt.Errorf("irisim: confirmation rate must be from 0 to 1")
+	}
+	if params.ValueBundleRate < 0 || params.ValueBundleRate > 1 {
+		return fmt.Errorf("irisim: value bundle rate must be from 0 to 1")
+	}
+	for i, np := range params.Nodes {
+		if np.DropRate < 0 || np.DropRate > 1 {
+			return fmt.Errorf("irisim: drop rate of node %v must be from 0 to 1", i)
+		}
+		if np.LagMs < 0 || np.JitterMs < 0 || np.OutOfSyncMilestones < 0 {
+			return fmt.Errorf("irisim: lag, jitter and out of sync parameters of node %v can't be negative", i)
+		}
+	}
+	return nil
+}
+
+func newSimulator(params Params, sinks []MsgSink) *Simulator {
+	ret := &Simulator{
+		params:         params,
+		clock:          params.Clock,
+		nodes:          make([]*simNode, len(params.Nodes)),
+		rnd:            rand.New(rand.NewSource(params.Seed)),
+		milestoneIndex: params.StartMilestoneIndex,
+		pending:        make([]*simTx, 0),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	for i := range params.Nodes {
+		uri := fmt.Sprintf("tcp://%v:%v", params.Host, params.BasePort+i)
+		ret.nodes[i] = newSimNode(i, uri, params.Nodes[i], sinks[i])
+	}
+	return ret
+}
+
+// URIs of simulated nodes in the order of params.Nodes
+func (sim *Simulator) URIs() []string {
+	ret := make([]string, len(sim.nodes))
+	for i, n := range sim.nodes {
+		ret[i] = n.uri
+	}
+	return ret
+}
+
+func (sim *Simulator) Start() {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	if sim.started {
+		return
+	}
+	sim.started = true
+	for _, n := range sim.nodes {
+		go n.run(sim.clock)
+	}
+	go sim.generatorLoop()
+	infof("IRI simulator started: %v nodes, TPS = %v, confirmation rate = %v, milestone interval = %v sec",
+		len(sim.nodes), sim.params.TPS, sim.params.ConfirmationRate, sim.params.MilestoneIntervalSec)
+}
+
+// Stop stops generation and closes sinks. Messages still in queues are lost
+func (sim *Simulator) Stop() {
+	sim.mutex.Lock()
+	if !sim.started {
+		sim.mutex.Unlock()
+		return
+	}
+	sim.started = false
+	sim.mutex.Unlock()
+
+	close(sim.stop)
+	<-sim.done
+	for _, n := range sim.nodes {
+		n.close()
+	}
+	infof("IRI simulator stopped")
+}
+
+func (sim *Simulator) GetStats() Stats {
+	sim.mutex.RLock()
+	ret := sim.stats
+	ret.MilestoneIndex = sim.milestoneIndex
+	sim.mutex.RUnlock()
+
+	ret.Nodes = make([]NodeStats, len(sim.nodes))
+	for i, n := range sim.nodes {
+		ret.Nodes[i] = n.getStats()
+	}
+	return ret
+}
+
+// Advance moves the ManualClock forward by d and runs the simulation synchronously in steps of generatorLoopPeriod:
+// generates transactions and milestones and delivers messages which are due to sinks.
+// Used instead of Start
+func (sim *Simulator) Advance(d time.Duration) error {
+	clock, ok := sim.clock.(*ManualClock)
+	if !ok {
+		return fmt.Errorf("irisim: Advance requires ManualClock")
+	}
+	sim.mutex.RLock()
+	started := sim.started
+	sim.mutex.RUnlock()
+	if started {
+		return fmt.Errorf("irisim: simulator was started, can't advance it")
+	}
+	if !sim.generating {
+		sim.step(clock.Now())
+	}
+	for ; d > 0; d -= generatorLoopPeriod {
+		step := generatorLoopPeriod
+		if d < step {
+			step = d
+		}
+		sim.step(clock.add(step))
+	}
+	return nil
+}
+
+func (sim *Simulator) step(now time.Time) {
+	sim.generate(now)
+	for _, n := range sim.nodes {
+		n.deliver(now)
+	}
+}
+
+func (sim *Simulator) generatorLoop() {
+	defer close(sim.done)
+
+	ticker := time.NewTicker(generatorLoopPeriod)
+	defer ticker.Stop()
+	sim.generate(sim.clock.Now())
+	for {
+		select {
+		case <-sim.stop:
+			return
+		case <-ticker.C:
+			sim.generate(sim.clock.Now())
+		}
+	}
+}
+
+// generates transactions and milestones due by 'now'
+func (sim *Simulator) generate(now time.Time) {
+	msInterval := time.Duration(sim.params.MilestoneIntervalSec * float64(time.Second))
+	if !sim.generating {
+		// first milestone is issued immediately: Tanglebeat ignores tx and sn until first lmi arrives
+		sim.generating = true
+		sim.issueMilestone(false)
+		sim.lastGen = now
+		sim.nextMilestone = now.Add(msInterval)
+		return
+	}
+	for !now.Before(sim.nextMilestone) {
+		sim.issueMilestone(true)
+		sim.nextMilestone = sim.nextMilestone.Add(msInterval)
+	}
+	sim.txAcc += sim.params.TPS * now.Sub(sim.lastGen).Seconds()
+	sim.lastGen = now
+	for sim.txAcc >= 1 {
+		sim.txAcc -= float64(sim.generateBundle())
+	}
+}
+
+func (sim *Simulator) randomTrytes(n int) string {
+	var b strings.Builder
+	b.Grow(n)
+	for i := 0; i < n; i++ {
+		b.WriteByte(tryteAlphabet[sim.rnd.Intn(len(tryteAlphabet))])
+	}
+	return b.String()
+}
+
+// generates bundle, sends its transactions to nodes and returns number of transactions in it
+func (sim *Simulator) generateBundle() int {
+	var values []int64
+	isValue := sim.rnd.Float64() < sim.params.ValueBundleRate
+	if isValue {
+		// output, input, second half of the input signature
+		v := int64(1 + sim.rnd.Intn(1000000))
+		values = []int64{v, -v, 0}
+	} else {
+		values = make([]int64, 1+sim.rnd.Intn(2))
+	}
+	bundle := sim.randomTrytes(hashLen)
+	tag := sim.randomTrytes(tagLen)
+	inputAddr := sim.randomTrytes(hashLen)
+	confirm := sim.rnd.Float64() < sim.params.ConfirmationRate
+	ts := sim.clock.Now()
+	lastIndex := len(values) - 1
+
+	txs := make([]*simTx, len(values))
+	for i := range txs {
+		txs[i] = &simTx{
+			hash:   sim.randomTrytes(hashLen),
+			bundle: bundle,
+			trunk:  sim.randomTrytes(hashLen),
+			branch: sim.randomTrytes(hashLen),
+		}
+		if isValue && i > 0 {
+			txs[i].address = inputAddr
+		} else {
+			txs[i].address = sim.randomTrytes(hashLen)
+		}
+	}
+	for i := range txs {
+		// trunk of non-tail transactions is the next transaction in the bundle
+		if i < lastIndex {
+			txs[i].trunk = txs[i+1].hash
+		}
+		// tx <hash> <address> <value> <obsoleteTag> <timestamp> <currentIndex> <lastIndex> <bundle> <trunk> <branch> <arrivalTime> <tag>
+		msg := fmt.Sprintf("tx %v %v %v %v %v %v %v %v %v %v %v %v",
+			txs[i].hash, txs[i].address, values[i], tag, ts.Unix(), i, lastIndex,
+			bundle, txs[i].trunk, txs[i].branch, ts.UnixNano()/int64(time.Millisecond), tag)
+		sim.broadcast([]byte(msg), false)
+	}
+
+	sim.mutex.Lock()
+	sim.stats.NumTx += uint64(len(txs))
+	sim.stats.NumBundles++
+	if isValue {
+		sim.stats.NumValueBundles++
+	}
+	if confirm {
+		sim.pending = append(sim.pending, txs...)
+	}
+	sim.mutex.Unlock()
+	return len(txs)
+}
+
+// issues next milestone. If 'next' is false, current index is announced
+func (sim *Simulator) issueMilestone(next bool) {
+	sim.mutex.Lock()
+	prev := sim.milestoneIndex
+	if next {
+		sim.milestoneIndex++
+	} else {
+		prev--
+	}
+	index := sim.milestoneIndex
+	confirmed := sim.pending
+	sim.pending = make([]*simTx, 0)
+	sim.stats.NumConfirmedTx += uint64(len(confirmed))
+	sim.mutex.Unlock()
+
+	sim.broadcast([]byte(fmt.Sprintf("lmi %v %v", prev, index)), true)
+	sim.broadcast([]byte(fmt.Sprintf("lmhs %v", sim.randomTrytes(hashLen))), true)
+	for _, tx := range confirmed {
+		// sn <milestoneIndex> <hash> <address> <trunk> <branch> <bundle>
+		msg := fmt.Sprintf("sn %v %v %v %v %v %v", index, tx.hash, tx.address, tx.trunk, tx.branch, tx.bundle)
+		sim.broadcast([]byte(msg), true)
+	}
+	debugf("IRI simulator: milestone %v confirmed %v transactions", index, len(confirmed))
+}
+
+// schedules message for each node according to its parameters
+func (sim *Simulator) broadcast(msg []byte, milestoneRelated bool) {
+	now := sim.clock.Now()
+	for _, n := range sim.nodes {
+		if n.params.DropRate > 0 && sim.rnd.Float64() < n.params.DropRate {
+			n.countDropped()
+			continue
+		}
+		delay := time.Duration(n.params.LagMs) * time.Millisecond
+		if n.params.JitterMs > 0 {
+			delay += time.Duration(sim.rnd.Intn(n.params.JitterMs+1)) * time.Millisecond
+		}
+		if milestoneRelated && n.params.OutOfSyncMilestones > 0 {
+			delay += time.Duration(float64(n.params.OutOfSyncMilestones) * sim.params.MilestoneIntervalSec * float64(time.Second))
+		}
+		n.schedule(msg, now.Add(delay))
+	}
+}
@@ -0,0 +1,170 @@
+package irisim
+
+import (
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSink struct {
+	sync.Mutex
+	msgs []string
+}
+
+func (s *testSink) Send(msg []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	s.msgs = append(s.msgs, string(msg))
+	return nil
+}
+
+func (s *testSink) Close() {}
+
+func (s *testSink) byTopic(topic string) []string {
+	s.Lock()
+	defer s.Unlock()
+	ret := make([]string, 0)
+	for _, m := range s.msgs {
+		if strings.HasPrefix(m, topic+" ") {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+func Test_Simulator(t *testing.T) {
+	params := Params{
+		Nodes: []NodeParams{
+			{},
+			{LagMs: 50, JitterMs: 50, DropRate: 0.5},
+			{OutOfSyncMilestones: 10},
+		},
+		TPS:                  100,
+		ConfirmationRate:     0.5,
+		ValueBundleRate:      0.3,
+		MilestoneIntervalSec: 1,
+		StartMilestoneIndex:  100,
+		Seed:                 1,
+		Clock:                NewManualClock(time.Unix(1500000000, 0)),
+	}
+	sinks := []*testSink{{}, {}, {}}
+	sim, err := NewSimulatorWithSinks(params, []MsgSink{sinks[0], sinks[1], sinks[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sim.Advance(3500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	stats := sim.GetStats()
+
+	if stats.MilestoneIndex != 103 {
+		t.Errorf("expected milestone index 103, got %v", stats.MilestoneIndex)
+	}
+	if stats.NumValueBundles == 0 || stats.NumConfirmedTx == 0 {
+		t.Errorf("expected value bundles and confirmations: %+v", stats)
+	}
+	// node without lag delivers everything immediately
+	queued0 := uint64(stats.Nodes[0].Queued)
+	if queued0 != 0 {
+		t.Errorf("node 0 has %v messages queued", queued0)
+	}
+	txs := sinks[0].byTopic("tx")
+	if uint64(len(txs)) != stats.NumTx {
+		t.Errorf("node 0 sent %v tx messages, expected %v", len(txs), stats.NumTx)
+	}
+	for _, m := range txs {
+		split := strings.Split(m, " ")
+		if len(split) != 13 || len(split[1]) != hashLen || len(split[8]) != hashLen {
+			t.Fatalf("wrong tx message '%v'", m)
+		}
+		if _, err := strconv.Atoi(split[3]); err != nil {
+			t.Fatalf("wrong value in tx message '%v'", m)
+		}
+	}
+	sns := sinks[0].byTopic("sn")
+	if uint64(len(sns)) != stats.NumConfirmedTx {
+		t.Errorf("node 0 sent %v sn messages, expected %v", len(sns), stats.NumConfirmedTx)
+	}
+	for _, m := range sns {
+		split := strings.Split(m, " ")
+		idx, err := strconv.Atoi(split[1])
+		if len(split) != 7 || err != nil || idx <= 100 || idx > 103 {
+			t.Fatalf("wrong sn message '%v'", m)
+		}
+	}
+	lmis := sinks[0].byTopic("lmi")
+	if len(lmis) != 4 || lmis[0] != "lmi 99 100" || lmis[3] != "lmi 102 103" {
+		t.Errorf("wrong lmi messages %v", lmis)
+	}
+
+	// lossy node
+	n1 := stats.Nodes[1]
+	if n1.Dropped == 0 || n1.Sent == 0 || n1.Sent+n1.Dropped+uint64(n1.Queued) != stats.Nodes[0].Sent+queued0 {
+		t.Errorf("wrong stats of the lossy node: %+v, node 0: %+v", n1, stats.Nodes[0])
+	}
+	// out of sync node must not have sent any milestone yet
+	if len(sinks[2].byTopic("lmi")) != 0 || len(sinks[2].byTopic("tx")) == 0 {
+		t.Errorf("out of sync node sent %v lmi and %v tx messages",
+			len(sinks[2].byTopic("lmi")), len(sinks[2].byTopic("tx")))
+	}
+}
+
+// same seed and same clock give the same messages
+func Test_SimulatorDeterministic(t *testing.T) {
+	run := func() []string {
+		params := Params{
+			Nodes:                []NodeParams{{LagMs: 50, JitterMs: 50, DropRate: 0.3}},
+			TPS:                  50,
+			ConfirmationRate:     0.5,
+			MilestoneIntervalSec: 1,
+			Seed:                 2,
+			Clock:                NewManualClock(time.Unix(1500000000, 0)),
+		}
+		sink := &testSink{}
+		sim, err := NewSimulatorWithSinks(params, []MsgSink{sink})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sim.Advance(2 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		return sink.msgs
+	}
+	msgs1 := run()
+	msgs2 := run()
+	if len(msgs1) == 0 || strings.Join(msgs1, "\n") != strings.Join(msgs2, "\n") {
+		t.Errorf("expected same messages, got %v and %v", len(msgs1), len(msgs2))
+	}
+}
+
+func Test_SimulatorZMQ(t *testing.T) {
+	params := Params{
+		BasePort:             15556,
+		Nodes:                []NodeParams{{}},
+		TPS:                  10,
+		MilestoneIntervalSec: 1,
+	}
+	sim, err := NewSimulator(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+
+	sock, err := utils.OpenSocketAndSubscribe(sim.URIs()[0], []string{"lmi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+	sim.Start()
+
+	msg, err := sock.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Frames) == 0 || !strings.HasPrefix(string(msg.Frames[0]), "lmi ") {
+		t.Errorf("expected lmi message, got %+v", msg)
+	}
+}
@@ -0,0 +1,63 @@
+package irisim
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-zeromq/zmq4"
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/protocol/pub"
+	"nanomsg.org/go-mangos/transport/tcp"
+)
+
+// MsgSink is where simulated node sends its messages.
+// Simulator opens ZMQ or Nanomsg PUB sockets by default, tests may use any other sink
+type MsgSink interface {
+	Send(msg []byte) error
+	Close()
+}
+
+type zmqSink struct {
+	sock zmq4.Socket
+}
+
+func newZmqSink(port int) (MsgSink, error) {
+	sock := zmq4.NewPub(context.Background())
+	if err := sock.Listen(fmt.Sprintf("tcp://*:%v", port)); err != nil {
+		_ = sock.Close()
+		return nil, fmt.Errorf("can't listen ZMQ pub socket on port %v: %v", port, err)
+	}
+	return &zmqSink{sock: sock}, nil
+}
+
+func (s *zmqSink) Send(msg []byte) error {
+	return s.sock.Send(zmq4.NewMsg(msg))
+}
+
+func (s *zmqSink) Close() {
+	_ = s.sock.Close()
+}
+
+type nanomsgSink struct {
+	sock mangos.Socket
+}
+
+func newNanomsgSink(port int) (MsgSink, error) {
+	sock, err := pub.NewSocket()
+	if err != nil {
+		return nil, fmt.Errorf("can't create Nanomsg pub socket: %v", err)
+	}
+	sock.AddTransport(tcp.NewTransport())
+	if err = sock.Listen(fmt.Sprintf("tcp://:%v", port)); err != nil {
+		_ = sock.Close()
+		return nil, fmt.Errorf("can't listen Nanomsg pub socket on port %v: %v", port, err)
+	}
+	return &nanomsgSink{sock: sock}, nil
+}
+
+func (s *nanomsgSink) Send(msg []byte) error {
+	return s.sock.Send(msg)
+}
+
+func (s *nanomsgSink) Close() {
+	_ = s.sock.Close()
+}
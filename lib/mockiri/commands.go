@@ -0,0 +1,287 @@
+package mockiri
+
+import (
+	"fmt"
+	. "github.com/iotaledger/iota.go/consts"
+	. "github.com/iotaledger/iota.go/transaction"
+	. "github.com/iotaledger/iota.go/trinary"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// union of parameters of all supported commands
+type request struct {
+	Command            string   `json:"command"`
+	Addresses          Hashes   `json:"addresses"`
+	Bundles            Hashes   `json:"bundles"`
+	Tags               []Trytes `json:"tags"`
+	Approvees          Hashes   `json:"approvees"`
+	Hashes             Hashes   `json:"hashes"`
+	Transactions       Hashes   `json:"transactions"`
+	Tips               Hashes   `json:"tips"`
+	Tails              Hashes   `json:"tails"`
+	Trytes             []Trytes `json:"trytes"`
+	TrunkTransaction   Hash     `json:"trunkTransaction"`
+	BranchTransaction  Hash     `json:"branchTransaction"`
+	MinWeightMagnitude uint64   `json:"minWeightMagnitude"`
+	Threshold          uint64   `json:"threshold"`
+	Depth              uint64   `json:"depth"`
+}
+
+type commandFun func(m *MockIRI, req *request) (interface{}, error)
+
+var commands = map[string]commandFun{
+	"getNodeInfo":              getNodeInfo,
+	"getBalances":              getBalances,
+	"wereAddressesSpentFrom":   wereAddressesSpentFrom,
+	"findTransactions":         findTransactions,
+	"getTrytes":                getTrytes,
+	"getTransactionsToApprove": getTransactionsToApprove,
+	"attachToTangle":           attachToTangle,
+	"storeTransactions":        storeTransactions,
+	"broadcastTransactions":    broadcastTransactions,
+	"checkConsistency":         checkConsistency,
+	"getInclusionStates":       getInclusionStates,
+}
+
+type getNodeInfoResponse struct {
+	AppName                            string `json:"appName"`
+	AppVersion                         string `json:"appVersion"`
+	Duration                           int64  `json:"duration"`
+	LatestMilestone                    Hash   `json:"latestMilestone"`
+	LatestMilestoneIndex               int64  `json:"latestMilestoneIndex"`
+	LatestSolidSubtangleMilestone      Hash   `json:"latestSolidSubtangleMilestone"`
+	LatestSolidSubtangleMilestoneIndex int64  `json:"latestSolidSubtangleMilestoneIndex"`
+	MilestoneStartIndex                int64  `json:"milestoneStartIndex"`
+	Neighbors                          int64  `json:"neighbors"`
+	Time                               int64  `json:"time"`
+	Tips                               int64  `json:"tips"`
+	TransactionsToRequest              int64  `json:"transactionsToRequest"`
+}
+
+func getNodeInfo(m *MockIRI, req *request) (interface{}, error) {
+	return &getNodeInfoResponse{
+		AppName:                            "IRI mock",
+		AppVersion:                         "1.8.0",
+		LatestMilestone:                    m.milestone,
+		LatestMilestoneIndex:               m.milestoneIndex,
+		LatestSolidSubtangleMilestone:      m.milestone,
+		LatestSolidSubtangleMilestoneIndex: m.milestoneIndex,
+		MilestoneStartIndex:                startMilestoneIndex,
+		Time:                               time.Now().UnixNano() / int64(time.Millisecond),
+	}, nil
+}
+
+type getBalancesResponse struct {
+	Duration       int64    `json:"duration"`
+	Balances       []string `json:"balances"`
+	References     Hashes   `json:"references"`
+	MilestoneIndex int64    `json:"milestoneIndex"`
+}
+
+func getBalances(m *MockIRI, req *request) (interface{}, error) {
+	ret := &getBalancesResponse{
+		Balances:       make([]string, len(req.Addresses)),
+		References:     Hashes{m.milestone},
+		MilestoneIndex: m.milestoneIndex,
+	}
+	for i, addr := range req.Addresses {
+		ret.Balances[i] = strconv.FormatInt(m.balances[normalizeAddr(addr)], 10)
+	}
+	return ret, nil
+}
+
+type statesResponse struct {
+	Duration int64  `json:"duration"`
+	States   []bool `json:"states"`
+}
+
+func wereAddressesSpentFrom(m *MockIRI, req *request) (interface{}, error) {
+	ret := &statesResponse{States: make([]bool, len(req.Addresses))}
+	for i, addr := range req.Addresses {
+		ret.States[i] = m.spent[normalizeAddr(addr)]
+	}
+	return ret, nil
+}
+
+type hashesResponse struct {
+	Duration int64  `json:"duration"`
+	Hashes   Hashes `json:"hashes"`
+}
+
+// like IRI, returns intersection of results of all non empty criteria
+func findTransactions(m *MockIRI, req *request) (interface{}, error) {
+	var sets []map[Hash]bool
+	collect := func(keys []Trytes, index map[Hash]Hashes, normalize bool) {
+		if len(keys) == 0 {
+			return
+		}
+		set := make(map[Hash]bool)
+		for _, k := range keys {
+			if normalize {
+				k = normalizeAddr(k)
+			}
+			for _, h := range index[k] {
+				set[h] = true
+			}
+		}
+		sets = append(sets, set)
+	}
+	collect(req.Addresses, m.byAddress, true)
+	collect(req.Bundles, m.byBundle, false)
+	collect(req.Tags, m.byTag, false)
+	collect(req.Approvees, m.byApprovee, false)
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("invalid parameters: no search criteria")
+	}
+	ret := &hashesResponse{Hashes: make(Hashes, 0)}
+	for h := range sets[0] {
+		inAll := true
+		for _, s := range sets[1:] {
+			if !s[h] {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			ret.Hashes = append(ret.Hashes, h)
+		}
+	}
+	return ret, nil
+}
+
+type trytesResponse struct {
+	Duration int64    `json:"duration"`
+	Trytes   []Trytes `json:"trytes"`
+}
+
+var nullTxTrytes = Trytes(strings.Repeat("9", TransactionTrytesSize))
+
+func getTrytes(m *MockIRI, req *request) (interface{}, error) {
+	ret := &trytesResponse{Trytes: make([]Trytes, len(req.Hashes))}
+	for i, h := range req.Hashes {
+		if t, ok := m.trytes[h]; ok {
+			ret.Trytes[i] = t
+		} else {
+			ret.Trytes[i] = nullTxTrytes
+		}
+	}
+	return ret, nil
+}
+
+type getTransactionsToApproveResponse struct {
+	Duration          int64 `json:"duration"`
+	TrunkTransaction  Hash  `json:"trunkTransaction"`
+	BranchTransaction Hash  `json:"branchTransaction"`
+}
+
+// tips are random known transactions or random hashes if the tangle is empty
+func getTransactionsToApprove(m *MockIRI, req *request) (interface{}, error) {
+	tip := func() Hash {
+		for h := range m.txs {
+			return h
+		}
+		return m.randomHash()
+	}
+	return &getTransactionsToApproveResponse{
+		TrunkTransaction:  tip(),
+		BranchTransaction: tip(),
+	}, nil
+}
+
+// attaches transactions the same way IRI does, except PoW: nonce is random.
+// Returned trytes are in reverse order
+func attachToTangle(m *MockIRI, req *request) (interface{}, error) {
+	if len(req.Trytes) == 0 {
+		return nil, fmt.Errorf("invalid parameters: no trytes")
+	}
+	attached := make([]Trytes, len(req.Trytes))
+	var prevHash Hash
+	for i, t := range req.Trytes {
+		tx, err := AsTransactionObject(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trytes: %v", err)
+		}
+		if prevHash == "" {
+			tx.TrunkTransaction = req.TrunkTransaction
+			tx.BranchTransaction = req.BranchTransaction
+		} else {
+			tx.TrunkTransaction = prevHash
+			tx.BranchTransaction = req.TrunkTransaction
+		}
+		if strings.Trim(tx.Tag, "9") == "" {
+			tx.Tag = tx.ObsoleteTag
+		}
+		tx.AttachmentTimestamp = time.Now().UnixNano() / int64(time.Millisecond)
+		tx.AttachmentTimestampLowerBound = 0
+		tx.AttachmentTimestampUpperBound = maxTimestampTrytes
+		tx.Nonce = m.randomHash()[:27]
+
+		if attached[i], err = TransactionToTrytes(tx); err != nil {
+			return nil, err
+		}
+		txAttached, err := AsTransactionObject(attached[i])
+		if err != nil {
+			return nil, err
+		}
+		prevHash = txAttached.Hash
+	}
+	ret := &trytesResponse{Trytes: make([]Trytes, len(attached))}
+	for i := range attached {
+		ret.Trytes[i] = attached[len(attached)-1-i]
+	}
+	return ret, nil
+}
+
+type emptyResponse struct {
+	Duration int64 `json:"duration"`
+}
+
+func storeTransactions(m *MockIRI, req *request) (interface{}, error) {
+	if err := m.storeTrytes__(req.Trytes); err != nil {
+		return nil, err
+	}
+	return &emptyResponse{}, nil
+}
+
+// mock has no neighbors. Transactions are only checked
+func broadcastTransactions(m *MockIRI, req *request) (interface{}, error) {
+	for _, t := range req.Trytes {
+		if _, err := AsTransactionObject(t); err != nil {
+			return nil, fmt.Errorf("invalid trytes: %v", err)
+		}
+	}
+	return &emptyResponse{}, nil
+}
+
+type checkConsistencyResponse struct {
+	Duration int64  `json:"duration"`
+	State    bool   `json:"state"`
+	Info     string `json:"info"`
+}
+
+func checkConsistency(m *MockIRI, req *request) (interface{}, error) {
+	for _, tail := range req.Tails {
+		tx, ok := m.txs[tail]
+		if !ok {
+			return &checkConsistencyResponse{State: false, Info: tailsNotSolidInfo + tail}, nil
+		}
+		if tx.CurrentIndex != 0 {
+			return nil, fmt.Errorf("Invalid transaction, not a tail: %v", tail)
+		}
+		if m.inconsistent[tail] {
+			return &checkConsistencyResponse{State: false, Info: inconsistentTailInfo}, nil
+		}
+	}
+	return &checkConsistencyResponse{State: true}, nil
+}
+
+// tips are ignored: inclusion is checked against the latest milestone
+func getInclusionStates(m *MockIRI, req *request) (interface{}, error) {
+	ret := &statesResponse{States: make([]bool, len(req.Transactions))}
+	for i, h := range req.Transactions {
+		ret.States[i] = m.confirmed[h]
+	}
+	return ret, nil
+}
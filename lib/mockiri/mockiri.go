@@ -0,0 +1,346 @@
+package mockiri
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/iotaledger/iota.go/consts"
+	. "github.com/iotaledger/iota.go/transaction"
+	. "github.com/iotaledger/iota.go/trinary"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In-process fake IRI node for tests. It serves IRI HTTP API commands needed by
+// tbsender, confirmer and multiapi: getNodeInfo, getBalances, wereAddressesSpentFrom, findTransactions,
+// getTrytes, getTransactionsToApprove, attachToTangle (fake PoW), storeTransactions,
+// broadcastTransactions, checkConsistency and getInclusionStates.
+//
+// Ledger (balances and spent addresses) is set by the test. Bundles are confirmed either
+// explicitly with ConfirmBundle or automatically according to the confirmation schedule:
+// bundle is confirmed when it was attached given number of times and given time passed
+// since first attachment. Upon confirmation values of the last attachment of the bundle
+// are applied to the ledger and new milestone is issued
+
+type bundleState struct {
+	firstSeen   time.Time
+	attachments []Hashes // transaction hashes of each attachment of the bundle
+	confirmed   bool
+}
+
+type MockIRI struct {
+	sync.Mutex
+	server         *httptest.Server
+	rnd            *rand.Rand
+	trytes         map[Hash]Trytes
+	txs            map[Hash]*Transaction
+	byAddress      map[Hash]Hashes
+	byBundle       map[Hash]Hashes
+	byTag          map[Trytes]Hashes
+	byApprovee     map[Hash]Hashes
+	bundles        map[Hash]*bundleState
+	confirmed      map[Hash]bool
+	inconsistent   map[Hash]bool
+	balances       map[Hash]int64
+	spent          map[Hash]bool
+	milestone      Hash
+	milestoneIndex int64
+
+	confirmAfterAttachments int
+	confirmDelay            time.Duration
+	latency                 time.Duration
+	failures                map[string]string
+	calls                   map[string]int
+}
+
+const (
+	startMilestoneIndex  = 1000000
+	maxTimestampTrytes   = 3812798742493 // (3^27-1)/2
+	commandNotSupported  = "Command [%v] is unknown"
+	tailsNotSolidInfo    = "tails are not solid (missing a referenced tx): "
+	inconsistentTailInfo = "tails are not consistent (would lead to inconsistent ledger state or below max depth)"
+)
+
+// New starts HTTP server of the mock on the random local port
+func New() *MockIRI {
+	ret := &MockIRI{
+		rnd:            rand.New(rand.NewSource(time.Now().UnixNano())),
+		trytes:         make(map[Hash]Trytes),
+		txs:            make(map[Hash]*Transaction),
+		byAddress:      make(map[Hash]Hashes),
+		byBundle:       make(map[Hash]Hashes),
+		byTag:          make(map[Trytes]Hashes),
+		byApprovee:     make(map[Hash]Hashes),
+		bundles:        make(map[Hash]*bundleState),
+		confirmed:      make(map[Hash]bool),
+		inconsistent:   make(map[Hash]bool),
+		balances:       make(map[Hash]int64),
+		spent:          make(map[Hash]bool),
+		milestoneIndex: startMilestoneIndex,
+		failures:       make(map[string]string),
+		calls:          make(map[string]int),
+	}
+	ret.milestone = ret.randomHash()
+	ret.server = httptest.NewServer(http.HandlerFunc(ret.handle))
+	return ret
+}
+
+// URL of the node to be used as IOTA node endpoint
+func (m *MockIRI) URL() string {
+	return m.server.URL
+}
+
+func (m *MockIRI) Close() {
+	m.server.Close()
+}
+
+// addresses are stored without checksum
+func normalizeAddr(addr Hash) Hash {
+	if len(addr) == AddressWithChecksumTrytesSize {
+		return addr[:HashTrytesSize]
+	}
+	return addr
+}
+
+func (m *MockIRI) randomHash() Hash {
+	var b strings.Builder
+	for i := 0; i < HashTrytesSize; i++ {
+		b.WriteByte("9ABCDEFGHIJKLMNOPQRSTUVWXYZ"[m.rnd.Intn(27)])
+	}
+	return b.String()
+}
+
+// ------------------ control of the mock by tests
+
+func (m *MockIRI) SetBalance(addr Hash, balance uint64) {
+	m.Lock()
+	defer m.Unlock()
+	m.balances[normalizeAddr(addr)] = int64(balance)
+}
+
+func (m *MockIRI) GetBalance(addr Hash) uint64 {
+	m.Lock()
+	defer m.Unlock()
+	m.processSchedule__()
+	return uint64(m.balances[normalizeAddr(addr)])
+}
+
+func (m *MockIRI) SetSpent(addr Hash, spent bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.spent[normalizeAddr(addr)] = spent
+}
+
+func (m *MockIRI) IsSpent(addr Hash) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.spent[normalizeAddr(addr)]
+}
+
+// SetConfirmationSchedule: bundles are confirmed automatically after being attached at least
+// 'afterAttachments' times and 'delay' passed since first attachment.
+// afterAttachments <= 0 disables automatic confirmation (default)
+func (m *MockIRI) SetConfirmationSchedule(afterAttachments int, delay time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.confirmAfterAttachments = afterAttachments
+	m.confirmDelay = delay
+}
+
+// ConfirmBundle confirms last attachment of the bundle. Returns false if bundle is unknown
+func (m *MockIRI) ConfirmBundle(bundleHash Hash) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.confirmBundle__(bundleHash)
+}
+
+func (m *MockIRI) IsBundleConfirmed(bundleHash Hash) bool {
+	m.Lock()
+	defer m.Unlock()
+	m.processSchedule__()
+	bs, ok := m.bundles[bundleHash]
+	return ok && bs.confirmed
+}
+
+// NumAttachments returns how many times the bundle was attached (stored with different transaction hashes)
+func (m *MockIRI) NumAttachments(bundleHash Hash) int {
+	m.Lock()
+	defer m.Unlock()
+	if bs, ok := m.bundles[bundleHash]; ok {
+		return len(bs.attachments)
+	}
+	return 0
+}
+
+// SetInconsistent makes checkConsistency to return false for the tail
+func (m *MockIRI) SetInconsistent(tailHash Hash, inconsistent bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.inconsistent[tailHash] = inconsistent
+}
+
+// SetFailure makes the command to fail with the error message. Empty message removes the failure
+func (m *MockIRI) SetFailure(command string, errMsg string) {
+	m.Lock()
+	defer m.Unlock()
+	if errMsg == "" {
+		delete(m.failures, command)
+	} else {
+		m.failures[command] = errMsg
+	}
+}
+
+// SetLatency sets delay of each response
+func (m *MockIRI) SetLatency(latency time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.latency = latency
+}
+
+func (m *MockIRI) NumCalls(command string) int {
+	m.Lock()
+	defer m.Unlock()
+	return m.calls[command]
+}
+
+func (m *MockIRI) LatestMilestoneIndex() int64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.milestoneIndex
+}
+
+// AddTransactions stores transactions the same way as storeTransactions command does
+func (m *MockIRI) AddTransactions(trytes []Trytes) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.storeTrytes__(trytes)
+}
+
+// ------------------ ledger and tangle
+
+func (m *MockIRI) storeTrytes__(trytes []Trytes) error {
+	txs := make([]*Transaction, 0, len(trytes))
+	for _, t := range trytes {
+		tx, err := AsTransactionObject(t)
+		if err != nil {
+			return fmt.Errorf("invalid trytes: %v", err)
+		}
+		txs = append(txs, tx)
+	}
+	// new transactions of the same bundle in one call are considered one attachment
+	newByBundle := make(map[Hash]Hashes)
+	for i, tx := range txs {
+		if _, ok := m.txs[tx.Hash]; ok {
+			continue
+		}
+		m.txs[tx.Hash] = tx
+		m.trytes[tx.Hash] = trytes[i]
+		addr := normalizeAddr(tx.Address)
+		m.byAddress[addr] = append(m.byAddress[addr], tx.Hash)
+		m.byBundle[tx.Bundle] = append(m.byBundle[tx.Bundle], tx.Hash)
+		m.byTag[tx.Tag] = append(m.byTag[tx.Tag], tx.Hash)
+		m.byApprovee[tx.TrunkTransaction] = append(m.byApprovee[tx.TrunkTransaction], tx.Hash)
+		if tx.BranchTransaction != tx.TrunkTransaction {
+			m.byApprovee[tx.BranchTransaction] = append(m.byApprovee[tx.BranchTransaction], tx.Hash)
+		}
+		if tx.Value < 0 {
+			m.spent[addr] = true
+		}
+		newByBundle[tx.Bundle] = append(newByBundle[tx.Bundle], tx.Hash)
+	}
+	for bundleHash, hashes := range newByBundle {
+		bs, ok := m.bundles[bundleHash]
+		if !ok {
+			bs = &bundleState{firstSeen: time.Now()}
+			m.bundles[bundleHash] = bs
+		}
+		bs.attachments = append(bs.attachments, hashes)
+	}
+	return nil
+}
+
+func (m *MockIRI) confirmBundle__(bundleHash Hash) bool {
+	bs, ok := m.bundles[bundleHash]
+	if !ok {
+		return false
+	}
+	if bs.confirmed {
+		return true
+	}
+	bs.confirmed = true
+	for _, h := range bs.attachments[len(bs.attachments)-1] {
+		m.confirmed[h] = true
+		tx := m.txs[h]
+		if tx.Value != 0 {
+			m.balances[normalizeAddr(tx.Address)] += tx.Value
+		}
+	}
+	m.milestoneIndex++
+	m.milestone = m.randomHash()
+	return true
+}
+
+func (m *MockIRI) processSchedule__() {
+	if m.confirmAfterAttachments <= 0 {
+		return
+	}
+	for bundleHash, bs := range m.bundles {
+		if bs.confirmed || len(bs.attachments) < m.confirmAfterAttachments {
+			continue
+		}
+		if time.Since(bs.firstSeen) >= m.confirmDelay {
+			m.confirmBundle__(bundleHash)
+		}
+	}
+}
+
+// ------------------ HTTP
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (m *MockIRI) handle(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeResponse(w, http.StatusBadRequest, &errorResponse{Error: fmt.Sprintf("invalid JSON: %v", err)})
+		return
+	}
+	m.Lock()
+	m.calls[req.Command]++
+	latency := m.latency
+	failure, fail := m.failures[req.Command]
+	m.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if fail {
+		writeResponse(w, http.StatusBadRequest, &errorResponse{Error: failure})
+		return
+	}
+	cmd, ok := commands[req.Command]
+	if !ok {
+		writeResponse(w, http.StatusBadRequest, &errorResponse{Error: fmt.Sprintf(commandNotSupported, req.Command)})
+		return
+	}
+	m.Lock()
+	m.processSchedule__()
+	resp, err := cmd(m, &req)
+	m.Unlock()
+
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, &errorResponse{Error: err.Error()})
+		return
+	}
+	writeResponse(w, http.StatusOK, resp)
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,179 @@
+package mockiri
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/iotaledger/iota.go/transaction"
+	. "github.com/iotaledger/iota.go/trinary"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hash4test(c string) Hash {
+	return Trytes(strings.Repeat(c, 81))
+}
+
+// value bundle of 2 transactions: addrFrom -> addrTo
+func bundle4test(t *testing.T, addrFrom, addrTo Hash, value int64) []Trytes {
+	txs := []*Transaction{
+		{Address: addrTo, Value: value, CurrentIndex: 0, LastIndex: 1},
+		{Address: addrFrom, Value: -value, CurrentIndex: 1, LastIndex: 1},
+	}
+	ret := make([]Trytes, 0, len(txs))
+	// like PrepareTransfers, last transaction goes first
+	for i := len(txs) - 1; i >= 0; i-- {
+		txs[i].Bundle = hash4test("B")
+		txs[i].ObsoleteTag = Trytes(strings.Repeat("T", 27))
+		txs[i].Timestamp = uint64(time.Now().Unix())
+		tr, err := TransactionToTrytes(txs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, tr)
+	}
+	return ret
+}
+
+func call(t *testing.T, m *MockIRI, req map[string]interface{}, resp interface{}) int {
+	data, _ := json.Marshal(req)
+	r, err := http.Post(m.URL(), "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if err = json.NewDecoder(r.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	return r.StatusCode
+}
+
+func attachAndStore(t *testing.T, m *MockIRI, trytes []Trytes) []Trytes {
+	var attResp trytesResponse
+	call(t, m, map[string]interface{}{
+		"command":            "attachToTangle",
+		"trunkTransaction":   hash4test("X"),
+		"branchTransaction":  hash4test("Y"),
+		"minWeightMagnitude": 14,
+		"trytes":             trytes,
+	}, &attResp)
+	if len(attResp.Trytes) != len(trytes) {
+		t.Fatalf("attachToTangle returned %v trytes", len(attResp.Trytes))
+	}
+	var empty emptyResponse
+	if call(t, m, map[string]interface{}{"command": "storeTransactions", "trytes": attResp.Trytes}, &empty) != http.StatusOK {
+		t.Fatalf("storeTransactions failed")
+	}
+	return attResp.Trytes
+}
+
+func Test_MockLedgerAndConfirmation(t *testing.T) {
+	m := New()
+	defer m.Close()
+
+	addrFrom, addrTo := hash4test("A"), hash4test("C")
+	m.SetBalance(addrFrom, 100)
+
+	attached := attachAndStore(t, m, bundle4test(t, addrFrom, addrTo, 100))
+	tail, _ := AsTransactionObject(attached[0])
+	second, _ := AsTransactionObject(attached[1])
+	if tail.CurrentIndex != 0 || tail.TrunkTransaction != second.Hash || tail.BranchTransaction != hash4test("X") {
+		t.Errorf("wrong chaining of attached transactions")
+	}
+	if second.TrunkTransaction != hash4test("X") || second.BranchTransaction != hash4test("Y") {
+		t.Errorf("wrong trunk and branch of the last transaction")
+	}
+
+	var spentResp statesResponse
+	call(t, m, map[string]interface{}{"command": "wereAddressesSpentFrom", "addresses": Hashes{addrFrom + "ABCDEFGHI", addrTo}}, &spentResp)
+	if len(spentResp.States) != 2 || !spentResp.States[0] || spentResp.States[1] {
+		t.Errorf("wrong spent states %v", spentResp.States)
+	}
+
+	var ftResp hashesResponse
+	call(t, m, map[string]interface{}{"command": "findTransactions", "bundles": Hashes{hash4test("B")}, "addresses": Hashes{addrTo}}, &ftResp)
+	if len(ftResp.Hashes) != 1 || ftResp.Hashes[0] != tail.Hash {
+		t.Errorf("findTransactions returned %v", ftResp.Hashes)
+	}
+
+	var gtResp trytesResponse
+	call(t, m, map[string]interface{}{"command": "getTrytes", "hashes": Hashes{tail.Hash, hash4test("Z")}}, &gtResp)
+	if len(gtResp.Trytes) != 2 || gtResp.Trytes[0] != attached[0] || gtResp.Trytes[1] != nullTxTrytes {
+		t.Errorf("wrong result of getTrytes")
+	}
+
+	var inclResp statesResponse
+	call(t, m, map[string]interface{}{"command": "getInclusionStates", "transactions": Hashes{tail.Hash}}, &inclResp)
+	if len(inclResp.States) != 1 || inclResp.States[0] {
+		t.Errorf("must not be confirmed")
+	}
+	msIndex := m.LatestMilestoneIndex()
+	if !m.ConfirmBundle(hash4test("B")) {
+		t.Fatalf("bundle not found")
+	}
+	call(t, m, map[string]interface{}{"command": "getInclusionStates", "transactions": Hashes{tail.Hash}}, &inclResp)
+	if !inclResp.States[0] || m.LatestMilestoneIndex() != msIndex+1 {
+		t.Errorf("must be confirmed by new milestone")
+	}
+	var balResp getBalancesResponse
+	call(t, m, map[string]interface{}{"command": "getBalances", "addresses": Hashes{addrFrom, addrTo}, "threshold": 100}, &balResp)
+	if len(balResp.Balances) != 2 || balResp.Balances[0] != "0" || balResp.Balances[1] != "100" {
+		t.Errorf("wrong balances after confirmation: %v", balResp.Balances)
+	}
+}
+
+func Test_MockSchedule(t *testing.T) {
+	m := New()
+	defer m.Close()
+	m.SetConfirmationSchedule(2, 0)
+
+	attached := attachAndStore(t, m, bundle4test(t, hash4test("A"), hash4test("C"), 0))
+	tail, _ := AsTransactionObject(attached[0])
+
+	var ccResp checkConsistencyResponse
+	call(t, m, map[string]interface{}{"command": "checkConsistency", "tails": Hashes{tail.Hash}}, &ccResp)
+	if !ccResp.State {
+		t.Errorf("must be consistent")
+	}
+	m.SetInconsistent(tail.Hash, true)
+	call(t, m, map[string]interface{}{"command": "checkConsistency", "tails": Hashes{tail.Hash}}, &ccResp)
+	if ccResp.State {
+		t.Errorf("must be inconsistent")
+	}
+	call(t, m, map[string]interface{}{"command": "checkConsistency", "tails": Hashes{hash4test("Z")}}, &ccResp)
+	if ccResp.State || !strings.Contains(ccResp.Info, "not solid") {
+		t.Errorf("unknown tail must be not solid")
+	}
+
+	if m.IsBundleConfirmed(hash4test("B")) {
+		t.Errorf("must not be confirmed after first attachment")
+	}
+	// reattach
+	attachAndStore(t, m, bundle4test(t, hash4test("A"), hash4test("C"), 0))
+	if m.NumAttachments(hash4test("B")) != 2 || !m.IsBundleConfirmed(hash4test("B")) {
+		t.Errorf("must be confirmed after second attachment")
+	}
+}
+
+func Test_MockFailures(t *testing.T) {
+	m := New()
+	defer m.Close()
+
+	var errResp errorResponse
+	if call(t, m, map[string]interface{}{"command": "unknownCommand"}, &errResp) != http.StatusBadRequest {
+		t.Errorf("unknown command must fail")
+	}
+	m.SetFailure("getNodeInfo", "node is down")
+	if call(t, m, map[string]interface{}{"command": "getNodeInfo"}, &errResp) != http.StatusBadRequest || errResp.Error != "node is down" {
+		t.Errorf("expected injected failure, got '%v'", errResp.Error)
+	}
+	m.SetFailure("getNodeInfo", "")
+	var niResp getNodeInfoResponse
+	if call(t, m, map[string]interface{}{"command": "getNodeInfo"}, &niResp) != http.StatusOK || niResp.LatestMilestoneIndex != startMilestoneIndex {
+		t.Errorf("wrong getNodeInfo response %+v", niResp)
+	}
+	if m.NumCalls("getNodeInfo") != 2 {
+		t.Errorf("wrong number of calls")
+	}
+}
@@ -0,0 +1,176 @@
+package multiapi
+
+import (
+	. "github.com/iotaledger/iota.go/api"
+	. "github.com/iotaledger/iota.go/transaction"
+	. "github.com/iotaledger/iota.go/trinary"
+	"github.com/unioproject/tanglebeat/lib/mockiri"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tests of MultiAPI against in-process IRI mocks. Don't need network
+
+var addr4mock = Trytes(strings.Repeat("A", 81))
+
+func newMocks4test(n int) ([]*mockiri.MockIRI, []string) {
+	mocks := make([]*mockiri.MockIRI, n)
+	urls := make([]string, n)
+	for i := range mocks {
+		mocks[i] = mockiri.New()
+		mocks[i].SetBalance(addr4mock, 1000)
+		urls[i] = mocks[i].URL()
+	}
+	return mocks, urls
+}
+
+func closeMocks(mocks []*mockiri.MockIRI) {
+	for _, m := range mocks {
+		m.Close()
+	}
+}
+
+func tx4mock(t *testing.T, bundleChar string) Trytes {
+	tx := &Transaction{
+		Address:   addr4mock,
+		Bundle:    Trytes(strings.Repeat(bundleChar, 81)),
+		Timestamp: uint64(time.Now().Unix()),
+	}
+	ret, err := TransactionToTrytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func Test_MockFirstSuccessfulResult(t *testing.T) {
+	mocks, urls := newMocks4test(3)
+	defer closeMocks(mocks)
+
+	mocks[0].SetLatency(500 * time.Millisecond)
+	mocks[1].SetFailure("getBalances", "internal error")
+
+	mapi, err := New(urls, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res MultiCallRet
+	bal, err := mapi.GetBalances(Hashes{addr4mock}, uint64(100), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bal.Balances) != 1 || bal.Balances[0] != 1000 {
+		t.Errorf("wrong balances %v", bal.Balances)
+	}
+	if res.Endpoint != urls[2] {
+		t.Errorf("expected result from the fastest successful endpoint %v, got from %v", urls[2], res.Endpoint)
+	}
+	if res.Duration >= 500*time.Millisecond {
+		t.Errorf("must not wait for the slow endpoint: %v", res.Duration)
+	}
+	for i, m := range mocks {
+		if m.NumCalls("getBalances") != 1 {
+			t.Errorf("endpoint #%v must be called once", i)
+		}
+	}
+}
+
+func Test_MockAllFailed(t *testing.T) {
+	mocks, urls := newMocks4test(2)
+	defer closeMocks(mocks)
+
+	for _, m := range mocks {
+		m.SetFailure("wereAddressesSpentFrom", "node is down")
+	}
+	mapi, _ := New(urls, 10)
+	var res MultiCallRet
+	if _, err := mapi.WereAddressesSpentFrom(addr4mock, &res); err == nil {
+		t.Errorf("must return error if all endpoints failed")
+	}
+	// single endpoint is called directly
+	if _, err := mapi[:1].WereAddressesSpentFrom(addr4mock, &res); err == nil || res.Endpoint != urls[0] {
+		t.Errorf("must return error of the first endpoint")
+	}
+	mocks[1].SetFailure("wereAddressesSpentFrom", "")
+	mocks[1].SetSpent(addr4mock, true)
+	spent, err := mapi.WereAddressesSpentFrom(addr4mock, &res)
+	if err != nil || len(spent) != 1 || !spent[0] || res.Endpoint != urls[1] {
+		t.Errorf("wrong result %v, err = %v from %v", spent, err, res.Endpoint)
+	}
+}
+
+func Test_MockSingleEndpoint(t *testing.T) {
+	mocks, urls := newMocks4test(1)
+	defer closeMocks(mocks)
+
+	mocks[0].SetLatency(50 * time.Millisecond)
+	mapi, err := New(urls, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// single endpoint is called directly, result is reported the same way as with several endpoints
+	var res MultiCallRet
+	bal, err := mapi.GetBalances(Hashes{addr4mock}, uint64(100), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bal.Balances) != 1 || bal.Balances[0] != 1000 {
+		t.Errorf("wrong balances %v", bal.Balances)
+	}
+	if res.Endpoint != urls[0] {
+		t.Errorf("expected result from %v, got from '%v'", urls[0], res.Endpoint)
+	}
+	if res.Duration < 50*time.Millisecond {
+		t.Errorf("duration of the call is not reported: %v", res.Duration)
+	}
+	if mocks[0].NumCalls("getBalances") != 1 {
+		t.Errorf("endpoint must be called once")
+	}
+}
+
+func Test_MockInclusionAndConsistency(t *testing.T) {
+	mocks, urls := newMocks4test(2)
+	defer closeMocks(mocks)
+
+	trytes := tx4mock(t, "B")
+	for _, m := range mocks {
+		if err := m.AddTransactions([]Trytes{trytes}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mapi, _ := New(urls, 10)
+
+	hashes, err := mapi.FindTransactions(FindTransactionsQuery{Bundles: Hashes{strings.Repeat("B", 81)}})
+	if err != nil || len(hashes) != 1 {
+		t.Fatalf("FindTransactions returned %v, err = %v", hashes, err)
+	}
+	txTrytes, err := mapi.GetTrytes(hashes[0])
+	if err != nil || len(txTrytes) != 1 || txTrytes[0] != trytes {
+		t.Errorf("GetTrytes returned wrong trytes, err = %v", err)
+	}
+
+	incl, err := mapi.GetLatestInclusion(hashes)
+	if err != nil || len(incl) != 1 || incl[0] {
+		t.Errorf("must not be confirmed: %v, err = %v", incl, err)
+	}
+	for _, m := range mocks {
+		m.ConfirmBundle(strings.Repeat("B", 81))
+	}
+	incl, err = mapi.GetLatestInclusion(hashes)
+	if err != nil || len(incl) != 1 || !incl[0] {
+		t.Errorf("must be confirmed: %v, err = %v", incl, err)
+	}
+
+	consistent, _, err := mapi.CheckConsistency(hashes[0])
+	if err != nil || !consistent {
+		t.Errorf("must be consistent, err = %v", err)
+	}
+	for _, m := range mocks {
+		m.SetInconsistent(hashes[0], true)
+	}
+	consistent, info, err := mapi.CheckConsistency(hashes[0])
+	if err != nil || consistent || info == "" {
+		t.Errorf("must be inconsistent, err = %v", err)
+	}
+}
@@ -86,7 +86,7 @@ func Test_polyCall(t *testing.T) {
 	gliResp1, err1 := api.GetLatestInclusion(txh4test)
 	fmt.Printf("fun = 'GetLatestInclusion' gliResp = %v err1 = %v\n", gliResp1, err1)
 
-	resp, err2 := __polyCall__(api, "GetLatestInclusion", txh4test)
+	resp, err2 := __polyCall__(api, "GetLatestInclusion", []interface{}{txh4test})
 	if err2 == nil {
 		gliResp2 := resp.([]bool)
 		fmt.Printf("fun = 'gli' resp = %v err1 = %v\n", gliResp2, err2)
@@ -100,7 +100,7 @@ func Test_polyCall(t *testing.T) {
 	gttaResp1, err1 := api.GetTransactionsToApprove(3)
 	fmt.Printf("fun = 'GetTransactionsToApprove' resp1 = %v err1 = %v\n", gttaResp1, err1)
 
-	gttaResp2tmp, err2 := __polyCall__(api, "GetTransactionsToApprove", uint64(3))
+	gttaResp2tmp, err2 := __polyCall__(api, "GetTransactionsToApprove", []interface{}{uint64(3)})
 	if err2 == nil {
 		_, ok := gttaResp2tmp.(*TransactionsToApprove)
 		if !ok {
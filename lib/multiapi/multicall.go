@@ -56,7 +56,11 @@ func (mapi MultiAPI) __multiCall__(funName string, retEndpoint *MultiCallRet, ar
 	var apiret MultiCallRet
 	if len(mapi) == 1 || MultiApiDisabled() {
 		// if there's one endpoint or multiapi is disabled, calling the first in the list
-		return mapi.__callFirst__(funName, &apiret, args)
+		ret, err := mapi.__callFirst__(funName, &apiret, args)
+		if retEndpoint != nil {
+			*retEndpoint = apiret
+		}
+		return ret, err
 	}
 
 	rnd := rand.Int() % 10000
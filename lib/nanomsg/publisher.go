@@ -1,12 +1,15 @@
 package nanomsg
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/op/go-logging"
 	"nanomsg.org/go-mangos"
 	"nanomsg.org/go-mangos/protocol/pub"
 	"nanomsg.org/go-mangos/transport/tcp"
+	"nanomsg.org/go-mangos/transport/tlstcp"
+	"sync"
 	"time"
 )
 
@@ -15,7 +18,11 @@ type Publisher struct {
 	chIn    chan []byte
 	sock    mangos.Socket
 	url     string
+	port    int
+	bufflen int
+	tlsCfg  *TLSServerYAML
 	log     *logging.Logger
+	mutex   sync.RWMutex
 }
 
 func (p *Publisher) Errorf(format string, args ...interface{}) {
@@ -44,30 +51,77 @@ func (p *Publisher) Debugf(format string, args ...interface{}) {
 
 // reads input stream of byte arrays and sends them to publish channel
 func NewPublisher(enabled bool, port int, bufflen int, localLog *logging.Logger) (*Publisher, error) {
-	ret := Publisher{
-		enabled: enabled,
+	return NewPublisherTLS(enabled, port, bufflen, nil, localLog)
+}
+
+// NewPublisherTLS creates publisher on 'tls+tcp://' if TLS config is not nil
+func NewPublisherTLS(enabled bool, port int, bufflen int, tlsCfg *TLSServerYAML, localLog *logging.Logger) (*Publisher, error) {
+	ret := &Publisher{
+		port:    port,
+		bufflen: bufflen,
+		tlsCfg:  tlsCfg,
 		log:     localLog,
 	}
 	if !enabled {
-		return &ret, nil
+		return ret, nil
+	}
+	if err := ret.open(); err != nil {
+		return nil, err
 	}
+	ret.enabled = true
+	return ret, nil
+}
+
+func (p *Publisher) open() error {
 	var err error
-	if ret.sock, err = pub.NewSocket(); err != nil {
-		return nil, fmt.Errorf("can't get new sub socket: %v", err)
+	if p.sock, err = pub.NewSocket(); err != nil {
+		return fmt.Errorf("can't get new sub socket: %v", err)
 	}
 
-	ret.chIn = make(chan []byte, bufflen)
-	ret.sock.AddTransport(tcp.NewTransport())
-	ret.url = fmt.Sprintf("tcp://:%v", port)
-	if err = ret.sock.Listen(ret.url); err != nil {
-		return nil, fmt.Errorf("can't listen new pub socket: %v", err)
+	p.chIn = make(chan []byte, p.bufflen)
+	if p.tlsCfg == nil {
+		p.sock.AddTransport(tcp.NewTransport())
+		p.url = fmt.Sprintf("tcp://:%v", p.port)
+		err = p.sock.Listen(p.url)
+	} else {
+		p.sock.AddTransport(tlstcp.NewTransport())
+		p.url = fmt.Sprintf("%v:%v", tlsScheme, p.port)
+		var cfg *tls.Config
+		if cfg, err = p.tlsCfg.ServerConfig(); err == nil {
+			err = p.sock.ListenOptions(p.url, map[string]interface{}{mangos.OptionTLSConfig: cfg})
+		}
+	}
+	if err != nil {
+		_ = p.sock.Close()
+		p.sock = nil
+		return fmt.Errorf("can't listen new pub socket: %v", err)
 	}
-	ret.Infof("Publisher: PUB socket listening on %v", ret.url)
+	p.Infof("Publisher: PUB socket listening on %v", p.url)
 	go func() {
-		ret.loop()
-		ret.sock.Close()
+		p.loop()
+		p.sock.Close()
 	}()
-	return &ret, nil
+	return nil
+}
+
+// SetEnabled enables or disables publishing at runtime.
+// Socket is opened upon first enabling and is kept open when disabled
+func (p *Publisher) SetEnabled(enabled bool) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if enabled && p.sock == nil {
+		if err := p.open(); err != nil {
+			return err
+		}
+	}
+	p.enabled = enabled
+	return nil
+}
+
+func (p *Publisher) IsEnabled() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.enabled
 }
 
 func (p *Publisher) loop() {
@@ -80,7 +134,7 @@ func (p *Publisher) loop() {
 }
 
 func (p *Publisher) PublishData(data []byte) error {
-	if !p.enabled {
+	if !p.IsEnabled() {
 		return nil
 	}
 	select {
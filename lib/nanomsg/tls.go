@@ -0,0 +1,113 @@
+package nanomsg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"nanomsg.org/go-mangos"
+	"nanomsg.org/go-mangos/transport/tcp"
+	"nanomsg.org/go-mangos/transport/tlstcp"
+	"net"
+	"strings"
+)
+
+// TLS for Nanomsg sockets. Socket with 'tls+tcp://' uri uses TLS transport, 'tcp://' is plain
+
+const tlsScheme = "tls+tcp://"
+
+// TLSServerYAML is TLS of the server (publisher), part of config files.
+// Server needs certificate and key. If CA file is set, server requires client certificates signed by it
+type TLSServerYAML struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	CAFile   string `yaml:"caFile"`
+}
+
+// TLSClientYAML is TLS of the client (subscriber), part of config files.
+// Client verifies server with CA file or system CAs. Client certificate is optional.
+// Verification is skipped only for servers listed in 'insecureSkipVerify' (hosts or URIs)
+type TLSClientYAML struct {
+	CertFile           string   `yaml:"certFile"`
+	KeyFile            string   `yaml:"keyFile"`
+	CAFile             string   `yaml:"caFile"`
+	InsecureSkipVerify []string `yaml:"insecureSkipVerify"`
+}
+
+func IsTLS(uri string) bool {
+	return strings.HasPrefix(uri, tlsScheme)
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in '%v'", caFile)
+	}
+	return pool, nil
+}
+
+func (c *TLSServerYAML) ServerConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("TLS server needs 'certFile' and 'keyFile'")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	ret := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.CAFile != "" {
+		if ret.ClientCAs, err = loadCertPool(c.CAFile); err != nil {
+			return nil, err
+		}
+		ret.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return ret, nil
+}
+
+// ClientConfig returns TLS config to dial the 'tls+tcp://' uri
+func (c *TLSClientYAML) ClientConfig(uri string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(strings.TrimPrefix(uri, tlsScheme))
+	if err != nil {
+		return nil, err
+	}
+	ret := &tls.Config{ServerName: host}
+	for _, s := range c.InsecureSkipVerify {
+		if s == host || s == uri {
+			ret.InsecureSkipVerify = true
+		}
+	}
+	if c.CAFile != "" {
+		if ret.RootCAs, err = loadCertPool(c.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	if c.CertFile != "" && c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ret.Certificates = []tls.Certificate{cert}
+	}
+	return ret, nil
+}
+
+// Dial dials 'tcp://' or 'tls+tcp://' uri. TLS config may be nil for 'tcp://'
+func Dial(sock mangos.Socket, uri string, tlsCfg *TLSClientYAML) error {
+	if !IsTLS(uri) {
+		sock.AddTransport(tcp.NewTransport())
+		return sock.Dial(uri)
+	}
+	if tlsCfg == nil {
+		return fmt.Errorf("can't dial '%v': TLS is not configured", uri)
+	}
+	cfg, err := tlsCfg.ClientConfig(uri)
+	if err != nil {
+		return fmt.Errorf("can't dial '%v': %v", uri, err)
+	}
+	sock.AddTransport(tlstcp.NewTransport())
+	return sock.DialOptions(uri, map[string]interface{}{mangos.OptionTLSConfig: cfg})
+}
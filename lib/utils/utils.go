@@ -6,6 +6,8 @@ import (
 	. "github.com/iotaledger/iota.go/kerl"
 	. "github.com/iotaledger/iota.go/transaction"
 	. "github.com/iotaledger/iota.go/trinary"
+	"net"
+	"net/url"
 	"time"
 )
 
@@ -82,6 +84,15 @@ func StringInSlice(a string, list []string) bool {
 	return false
 }
 
+// IsIpAddr returns true if host of the uri is an IP address
+func IsIpAddr(uri string) bool {
+	p, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return net.ParseIP(p.Hostname()) != nil
+}
+
 // check consistency of the indices of the set and return error if not consistent
 func CheckBundle(txSet []Transaction) error {
 	if len(txSet) == 0 {
@@ -7,7 +7,12 @@ import (
 )
 
 func OpenSocket(uri string, timeoutSec int) (zmq4.Socket, error) {
-	ctx := context.Background()
+	return OpenSocketCtx(context.Background(), uri, timeoutSec)
+}
+
+// OpenSocketCtx opens socket which lives until context is cancelled.
+// Cancelling the context makes blocked Recv return
+func OpenSocketCtx(ctx context.Context, uri string, timeoutSec int) (zmq4.Socket, error) {
 	socket := zmq4.NewSub(ctx, zmq4.WithDialerTimeout(time.Duration(timeoutSec)*time.Second))
 	err := socket.Dial(uri)
 	return socket, err
@@ -16,7 +21,11 @@ func OpenSocket(uri string, timeoutSec int) (zmq4.Socket, error) {
 const openSockTimeoutSec = 5
 
 func OpenSocketAndSubscribe(uri string, topics []string) (zmq4.Socket, error) {
-	socket, err := OpenSocket(uri, openSockTimeoutSec)
+	return OpenSocketAndSubscribeCtx(context.Background(), uri, topics)
+}
+
+func OpenSocketAndSubscribeCtx(ctx context.Context, uri string, topics []string) (zmq4.Socket, error) {
+	socket, err := OpenSocketCtx(ctx, uri, openSockTimeoutSec)
 	if err != nil {
 		return nil, err
 	}
@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"github.com/op/go-logging"
 	"github.com/unioproject/tanglebeat/lib/config"
+	"github.com/unioproject/tanglebeat/lib/nanomsg"
+	"math"
 	"os"
+	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -23,30 +27,151 @@ func GetLog() *logging.Logger {
 }
 
 type inputsOutput struct {
-	OutputEnabled bool     `yaml:"outputEnabled"`
-	OutputPort    int      `yaml:"outputPort"`
-	InputsZMQ     []string `yaml:"inputsZMQ"`
-	InputsNanomsg []string `yaml:"inputsNanomsg"`
+	OutputEnabled      bool     `yaml:"outputEnabled"`
+	OutputPort         int      `yaml:"outputPort"`
+	OutputTLS          bool     `yaml:"outputTLS"`
+	OutputZMQPort      int      `yaml:"outputZMQPort"`
+	OutputWSEnabled    bool     `yaml:"outputWSEnabled"`
+	OutputWSMaxClients int      `yaml:"outputWSMaxClients"`
+	InputsZMQ          []string `yaml:"inputsZMQ"`
+	InputsNanomsg      []string `yaml:"inputsNanomsg"`
+	InputsReplay       []string `yaml:"inputsReplay"`
+}
+
+type WatchYAML struct {
+	Name      string   `yaml:"name"`
+	Addresses []string `yaml:"addresses"`
+	Webhook   string   `yaml:"webhook"`
+}
+
+type SyncLagYAML struct {
+	MaxLagMilestones int  `yaml:"maxLagMilestones"`
+	MaxStaleMin      int  `yaml:"maxStaleMin"`
+	CloseValve       bool `yaml:"closeValve"`
+}
+
+// ReconnectYAML is backoff of reconnection of inputs after errors
+type ReconnectYAML struct {
+	MinDelaySec int      `yaml:"minDelaySec"`
+	MaxDelaySec int      `yaml:"maxDelaySec"`
+	Jitter      *float64 `yaml:"jitter"` // nil is default. 0 means no jitter
+}
+
+func (r ReconnectYAML) String() string {
+	var jitter float64
+	if r.Jitter != nil {
+		jitter = *r.Jitter
+	}
+	return fmt.Sprintf("{minDelaySec: %v, maxDelaySec: %v, jitter: %v}", r.MinDelaySec, r.MaxDelaySec, jitter)
+}
+
+// DiscoveryYAML is automatic discovery of ZMQ inputs from the node registry
+type DiscoveryYAML struct {
+	RegistryFile    string   `yaml:"registryFile"`
+	RegistryURL     string   `yaml:"registryURL"`
+	PeriodMin       int      `yaml:"periodMin"`
+	MaxInputs       int      `yaml:"maxInputs"`
+	ProbeTimeoutSec int      `yaml:"probeTimeoutSec"`
+	Blocklist       []string `yaml:"blocklist"`
+}
+
+// TLSYAML is TLS of Nanomsg links: server side for outputs, client side for 'tls+tcp://' inputs
+type TLSYAML struct {
+	Server nanomsg.TLSServerYAML `yaml:"server"`
+	Client nanomsg.TLSClientYAML `yaml:"client"`
+}
+
+// HealthYAML is rolling health score of inputs and removal of bad inputs
+type HealthYAML struct {
+	WindowMin      int     `yaml:"windowMin"`
+	RemoveBelow    float64 `yaml:"removeBelow"`
+	RemoveAfterMin int     `yaml:"removeAfterMin"`
+	MinInputs      int     `yaml:"minInputs"` // inputs are not removed below that number or tx quorum
+}
+
+// FederationYAML is federation with upstream tanglebeat instances
+type FederationYAML struct {
+	InstanceId  string   `yaml:"instanceId"`
+	Upstreams   []string `yaml:"upstreams"`
+	MaxEvidence int      `yaml:"maxEvidence"`
+}
+
+// HAYAML is active/standby pair of instances which share the lease file
+type HAYAML struct {
+	LeaseFile string `yaml:"leaseFile"`
+	LeaseSec  int    `yaml:"leaseSec"`
+}
+
+// ValvePolicyYAML is one of output valve policies. Meaning of the threshold depends on the policy
+type ValvePolicyYAML struct {
+	Name      string  `yaml:"name"`
+	Threshold float64 `yaml:"threshold"`
+	HoldMin   int     `yaml:"holdMin"`
 }
 
 type ConfigStructYAML struct {
-	Debug                               bool         `yaml:"debug"`
-	WebServerPort                       int          `yaml:"webServerPort"`
-	IriMsgStream                        inputsOutput `yaml:"iriMsgStream"`
-	SenderMsgStream                     inputsOutput `yaml:"senderMsgStream"`
-	RetentionPeriodMin                  int          `yaml:"retentionPeriodMin"`
-	QuorumTxToPass                      int          `yaml:"quorumToPass"`
-	QuorumMilestoneHashToPass           int          `yaml:"quorumMilestoneHashToPass"`
-	TimeIntervalMilestoneHashToPassMsec uint64       `yaml:"timeIntervalMilestoneHashToPassMsec"`
-	MultiQuorumMetricsEnabled           bool         `yaml:"multiQuorumMetricsEnabled"`
-	QuorumUpdatesEnabled                bool         `yaml:"quorumUpdatesEnabled"`
-	QuorumUpdatesFrom                   int          `yaml:"quorumUpdatesFrom"`
-	QuorumUpdatesTo                     int          `yaml:"quorumUpdatesTo"`
-	SpawnCmd                            []string     `yaml:"spawnCmd"`
+	Debug                               bool               `yaml:"debug"`
+	WebServerPort                       int                `yaml:"webServerPort"`
+	IriMsgStream                        inputsOutput       `yaml:"iriMsgStream"`
+	SenderMsgStream                     inputsOutput       `yaml:"senderMsgStream"`
+	RetentionPeriodMin                  int                `yaml:"retentionPeriodMin"`
+	QuorumTxToPass                      int                `yaml:"quorumToPass"`
+	QuorumSnToPass                      int                `yaml:"quorumSnToPass"`
+	QuorumLmiToPass                     int                `yaml:"quorumLmiToPass"`
+	AdaptiveQuorum                      bool               `yaml:"adaptiveQuorum"`
+	WeightedQuorum                      bool               `yaml:"weightedQuorum"`
+	AutoWeights                         bool               `yaml:"autoWeights"`
+	InputWeights                        map[string]float64 `yaml:"inputWeights"`
+	QuorumMilestoneHashToPass           int                `yaml:"quorumMilestoneHashToPass"`
+	TimeIntervalMilestoneHashToPassMsec uint64             `yaml:"timeIntervalMilestoneHashToPassMsec"`
+	MultiQuorumMetricsEnabled           bool               `yaml:"multiQuorumMetricsEnabled"`
+	QuorumUpdatesEnabled                bool               `yaml:"quorumUpdatesEnabled"`
+	QuorumUpdatesFrom                   int                `yaml:"quorumUpdatesFrom"`
+	QuorumUpdatesTo                     int                `yaml:"quorumUpdatesTo"`
+	SpawnCmd                            []string           `yaml:"spawnCmd"`
+	AdminToken                          string             `yaml:"adminToken"`
+	InputsOverlayFile                   string             `yaml:"inputsOverlayFile"`
+	SnapshotFile                        string             `yaml:"snapshotFile"`
+	SnapshotPeriodMin                   int                `yaml:"snapshotPeriodMin"`
+	RecordDir                           string             `yaml:"recordDir"`
+	RecordRotateMin                     int                `yaml:"recordRotateMin"`
+	RecordMaxFiles                      int                `yaml:"recordMaxFiles"`
+	ReplaySpeed                         float64            `yaml:"replaySpeed"`
+	TransfersFeedSize                   int                `yaml:"transfersFeedSize"`
+	WatchList                           []WatchYAML        `yaml:"watchList"`
+	OutputFilters                       []string           `yaml:"outputFilters"`
+	InputMetricsMaskIP                  bool               `yaml:"inputMetricsMaskIP"`
+	SyncLag                             SyncLagYAML        `yaml:"syncLag"`
+	OutputValve                         []ValvePolicyYAML  `yaml:"outputValve"`
+	Reconnect                           ReconnectYAML      `yaml:"reconnect"`
+	InputSilenceTimeoutSec              int                `yaml:"inputSilenceTimeoutSec"`
+	Discovery                           DiscoveryYAML      `yaml:"discovery"`
+	Health                              HealthYAML         `yaml:"health"`
+	TLS                                 TLSYAML            `yaml:"tls"`
+	Federation                          FederationYAML     `yaml:"federation"`
+	HA                                  HAYAML             `yaml:"ha"`
 }
 
+// Config is the config read at startup, it is not changed afterwards.
+// Parameters which can be changed by reload must be read from the running config, see Get
 var Config = ConfigStructYAML{}
 
+var running atomic.Value // *ConfigStructYAML
+
+// Get returns the running config: Config or the latest reloaded one.
+// Returned structure is shared and must not be modified. Reload replaces it as a whole
+func Get() *ConfigStructYAML {
+	if ret, ok := running.Load().(*ConfigStructYAML); ok {
+		return ret
+	}
+	return &Config
+}
+
+// Set replaces the running config. Used by config reload
+func Set(c *ConfigStructYAML) {
+	running.Store(c)
+}
+
 func initLogging(msgBeforeLog []string) ([]string, bool) {
 	log = logging.MustGetLogger("tanglebeat")
 	backend := logging.NewLogBackend(os.Stderr, "", 0)
@@ -85,35 +210,198 @@ func MustReadConfig(cfgfile string) {
 		os.Exit(1)
 	}
 
+	setDefaults(&Config)
+
 	infof("Debug = %v", Config.Debug)
-	// default values
-	if Config.QuorumTxToPass == 0 {
-		Config.QuorumTxToPass = 2
-	}
-	if Config.RetentionPeriodMin == 0 {
-		Config.RetentionPeriodMin = 60
-	}
 	infof("Quorum to pass a message: TX message will be accepted after received %v times from different sources",
 		Config.QuorumTxToPass)
-
-	if Config.QuorumMilestoneHashToPass == 0 {
-		Config.QuorumMilestoneHashToPass = 3
-	}
-	if Config.TimeIntervalMilestoneHashToPassMsec == 0 {
-		Config.TimeIntervalMilestoneHashToPassMsec = 5000
-	}
+	infof("Quorum to pass SN message = %v, LMI message = %v. Adaptive quorum = %v",
+		Config.QuorumSnToPass, Config.QuorumLmiToPass, Config.AdaptiveQuorum)
+	infof("Weighted quorum = %v, auto weights = %v", Config.WeightedQuorum, Config.AutoWeights)
 	infof("MultiQuorum metrics enabled = %v", Config.MultiQuorumMetricsEnabled)
 	infof("QuorumUpdatesEnabled = %v", Config.QuorumUpdatesEnabled)
 	if Config.QuorumUpdatesEnabled {
-		if Config.QuorumUpdatesFrom == 0 {
-			Config.QuorumUpdatesFrom = 1
-		}
-		if Config.QuorumUpdatesTo == 0 {
-			Config.QuorumUpdatesTo = 5
-		}
 		infof("QuorumUpdatesFrom = %d, QuorumUpdatesTo = %d",
 			Config.QuorumUpdatesFrom, Config.QuorumUpdatesTo)
 	}
+	if Config.SnapshotFile == "" {
+		infof("Cache snapshots are DISABLED: 'snapshotFile' is not set")
+	} else {
+		infof("Cache snapshot will be saved to '%v' every %v min", Config.SnapshotFile, Config.SnapshotPeriodMin)
+	}
+	if Config.RecordDir != "" {
+		infof("Input messages will be recorded to '%v'. Files are rotated every %v min",
+			Config.RecordDir, Config.RecordRotateMin)
+	}
+	if Config.IriMsgStream.OutputTLS || Config.SenderMsgStream.OutputTLS {
+		infof("Nanomsg output over TLS: iriMsgStream = %v, senderMsgStream = %v",
+			Config.IriMsgStream.OutputTLS, Config.SenderMsgStream.OutputTLS)
+	}
+	if Config.IriMsgStream.OutputWSEnabled {
+		infof("WebSocket output is enabled on '/api1/stream', max %v clients", Config.IriMsgStream.OutputWSMaxClients)
+	}
+	infof("Input is lagging if it is more than %v milestones behind or its milestone doesn't advance for %v min",
+		Config.SyncLag.MaxLagMilestones, Config.SyncLag.MaxStaleMin)
+	valvePolicies := make([]string, 0, len(Config.OutputValve))
+	for _, p := range Config.OutputValve {
+		valvePolicies = append(valvePolicies, p.Name)
+	}
+	infof("Output valve policies: %v", valvePolicies)
+	infof("Reconnect of inputs after errors: after %v sec, doubling up to %v sec, jitter %v",
+		Config.Reconnect.MinDelaySec, Config.Reconnect.MaxDelaySec, *Config.Reconnect.Jitter)
+	infof("Input is restarted if it is silent for %v sec", Config.InputSilenceTimeoutSec)
+	if Config.Health.RemoveBelow > 0 {
+		infof("Inputs with health score below %v for %v min will be removed until restart, keeping at least %v inputs",
+			Config.Health.RemoveBelow, Config.Health.RemoveAfterMin, Config.Health.MinInputs)
+	}
+	if Config.Discovery.RegistryFile != "" || Config.Discovery.RegistryURL != "" {
+		infof("Discovery of inputs from registry '%v%v' every %v min, max %v inputs",
+			Config.Discovery.RegistryFile, Config.Discovery.RegistryURL, Config.Discovery.PeriodMin, Config.Discovery.MaxInputs)
+	}
+	infof("Instance id: '%v'", Config.Federation.InstanceId)
+	if len(Config.Federation.Upstreams) > 0 {
+		infof("Federation: evidence of %d upstream instance(s) is counted in tx quorum, max %v from one upstream",
+			len(Config.Federation.Upstreams), Config.Federation.MaxEvidence)
+	}
+	if Config.HA.LeaseFile != "" {
+		infof("Active/standby mode: lease file '%v', lease %v sec", Config.HA.LeaseFile, Config.HA.LeaseSec)
+	}
+	if Config.AdminToken == "" {
+		infof("Admin API is DISABLED: 'adminToken' is not set")
+	} else {
+		infof("Admin API is enabled. Changes of inputs are saved to '%v'", Config.InputsOverlayFile)
+	}
+}
+
+// ReadConfig reads config file into the new structure with default values set.
+// Used to reload config file at runtime. The current config is not changed
+func ReadConfig(cfgfile string) (*ConfigStructYAML, error) {
+	ret := &ConfigStructYAML{}
+	msg, _, success := config.ReadYAML(cfgfile, nil, ret)
+	if !success {
+		return nil, fmt.Errorf("failed to read config file '%v': %v", cfgfile, strings.Join(msg, ". "))
+	}
+	setDefaults(ret)
+	return ret, nil
+}
+
+// ValidateQuorums checks if SN and LMI quorums can be reached with the given number of inputs
+func ValidateQuorums(c *ConfigStructYAML, numInputs int) error {
+	if c.QuorumSnToPass > numInputs {
+		return fmt.Errorf("'quorumSnToPass' = %v is greater than number of inputs %v", c.QuorumSnToPass, numInputs)
+	}
+	if c.QuorumLmiToPass > numInputs {
+		return fmt.Errorf("'quorumLmiToPass' = %v is greater than number of inputs %v", c.QuorumLmiToPass, numInputs)
+	}
+	return nil
+}
+
+func setDefaults(c *ConfigStructYAML) {
+	if c.QuorumTxToPass == 0 {
+		c.QuorumTxToPass = 2
+	}
+	if c.QuorumSnToPass == 0 {
+		c.QuorumSnToPass = 3
+	}
+	if c.QuorumLmiToPass == 0 {
+		c.QuorumLmiToPass = 3
+	}
+	if c.RetentionPeriodMin == 0 {
+		c.RetentionPeriodMin = 60
+	}
+	if c.QuorumMilestoneHashToPass == 0 {
+		c.QuorumMilestoneHashToPass = 3
+	}
+	if c.TimeIntervalMilestoneHashToPassMsec == 0 {
+		c.TimeIntervalMilestoneHashToPassMsec = 5000
+	}
+	if c.QuorumUpdatesEnabled {
+		if c.QuorumUpdatesFrom == 0 {
+			c.QuorumUpdatesFrom = 1
+		}
+		if c.QuorumUpdatesTo == 0 {
+			c.QuorumUpdatesTo = 5
+		}
+	}
+	if c.SnapshotPeriodMin == 0 {
+		c.SnapshotPeriodMin = 5
+	}
+	if c.RecordRotateMin == 0 {
+		c.RecordRotateMin = 60
+	}
+	if c.ReplaySpeed == 0 {
+		c.ReplaySpeed = 1
+	}
+	if c.TransfersFeedSize == 0 {
+		c.TransfersFeedSize = 1000
+	}
+	if c.IriMsgStream.OutputWSMaxClients == 0 {
+		c.IriMsgStream.OutputWSMaxClients = 100
+	}
+	if c.SyncLag.MaxLagMilestones == 0 {
+		c.SyncLag.MaxLagMilestones = 2
+	}
+	if c.SyncLag.MaxStaleMin == 0 {
+		c.SyncLag.MaxStaleMin = 5
+	}
+	if len(c.OutputValve) == 0 {
+		// the only heuristic before valve policies were introduced
+		c.OutputValve = []ValvePolicyYAML{{Name: "spam"}}
+		if c.SyncLag.CloseValve {
+			c.OutputValve = append(c.OutputValve, ValvePolicyYAML{Name: "outOfSync"})
+		}
+	}
+	if c.Health.WindowMin == 0 {
+		c.Health.WindowMin = 60
+	}
+	if c.Health.RemoveAfterMin == 0 {
+		c.Health.RemoveAfterMin = 60
+	}
+	if c.Discovery.PeriodMin == 0 {
+		c.Discovery.PeriodMin = 10
+	}
+	if c.Discovery.MaxInputs == 0 {
+		c.Discovery.MaxInputs = 50
+	}
+	if c.Discovery.ProbeTimeoutSec == 0 {
+		c.Discovery.ProbeTimeoutSec = 10
+	}
+	if c.InputSilenceTimeoutSec == 0 {
+		c.InputSilenceTimeoutSec = 120
+	}
+	if c.Reconnect.MinDelaySec == 0 {
+		c.Reconnect.MinDelaySec = 15
+	}
+	if c.Reconnect.MaxDelaySec == 0 {
+		c.Reconnect.MaxDelaySec = 600
+	}
+	if c.Reconnect.MaxDelaySec < c.Reconnect.MinDelaySec {
+		c.Reconnect.MaxDelaySec = c.Reconnect.MinDelaySec
+	}
+	// jitter > 1 would make delay negative
+	jitter := 0.2
+	if c.Reconnect.Jitter != nil {
+		jitter = math.Max(0, math.Min(1, *c.Reconnect.Jitter))
+	}
+	c.Reconnect.Jitter = &jitter
+	if c.Federation.InstanceId == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "tanglebeat"
+		}
+		c.Federation.InstanceId = fmt.Sprintf("%v:%v", host, c.WebServerPort)
+	}
+	// id is a field of the message and an element of the comma separated list
+	c.Federation.InstanceId = strings.NewReplacer(" ", "_", ",", "_").Replace(c.Federation.InstanceId)
+	if c.Federation.MaxEvidence == 0 {
+		c.Federation.MaxEvidence = 5
+	}
+	if c.HA.LeaseSec == 0 {
+		c.HA.LeaseSec = 5
+	}
+	if c.InputsOverlayFile == "" {
+		c.InputsOverlayFile = "tanglebeat_inputs.yml"
+	}
 }
 
 func infof(format string, args ...interface{}) {
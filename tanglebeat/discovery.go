@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discovery of ZMQ inputs from the node registry.
+// Registry is a list of ZMQ URIs in JSON or YAML, read from the local file 'registryFile' or
+// fetched from 'registryURL'. Every 'periodMin' candidates are probed and those which send messages are added
+// as inputs, up to 'maxInputs' inputs in total. Inputs from the blocklist are never added.
+// Discovery removes only inputs it added itself: when they disappear from the registry or are blocklisted.
+// Inputs from the config file and from admin API are not touched. Discovered inputs are not saved.
+// When discovery is turned off by config reload, all discovered inputs are removed.
+// Discovered input removed with admin API is recorded in the overlay file and is not discovered again
+
+const (
+	discoveryRegistryTimeout = 30 * time.Second
+	discoveryMaxProbes       = 10 // concurrent probes
+)
+
+type nodeRegistry interface {
+	getCandidates() ([]string, error)
+}
+
+type fileRegistry struct {
+	fname string
+}
+
+type httpRegistry struct {
+	url string
+}
+
+var (
+	discoveredInputs = make(map[string]struct{})
+	discoveryMutex   sync.Mutex
+)
+
+func startDiscovery() {
+	go discoveryLoop()
+}
+
+func discoveryLoop() {
+	for {
+		// config may be reloaded
+		if registry := getNodeRegistry(&cfg.Get().Discovery); registry != nil {
+			discoverInputs(registry)
+		} else {
+			removeAllDiscovered()
+		}
+		time.Sleep(time.Duration(cfg.Get().Discovery.PeriodMin) * time.Minute)
+	}
+}
+
+// file takes precedence over URL
+func getNodeRegistry(c *cfg.DiscoveryYAML) nodeRegistry {
+	switch {
+	case c.RegistryFile != "":
+		return &fileRegistry{fname: c.RegistryFile}
+	case c.RegistryURL != "":
+		return &httpRegistry{url: c.RegistryURL}
+	}
+	return nil
+}
+
+func (r *fileRegistry) getCandidates() ([]string, error) {
+	data, err := ioutil.ReadFile(r.fname)
+	if err != nil {
+		return nil, err
+	}
+	return parseRegistry(data)
+}
+
+func (r *httpRegistry) getCandidates() ([]string, error) {
+	client := &http.Client{Timeout: discoveryRegistryTimeout}
+	resp, err := client.Get(r.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("'%v' returned %v", r.url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseRegistry(data)
+}
+
+// JSON is parsed as YAML. 'host:port' is taken as 'tcp://host:port'
+func parseRegistry(data []byte) ([]string, error) {
+	var lst []string
+	if err := yaml.Unmarshal(data, &lst); err != nil {
+		return nil, fmt.Errorf("expected list of URIs: %v", err)
+	}
+	ret := make([]string, 0, len(lst))
+	for _, uri := range lst {
+		uri = strings.TrimSpace(uri)
+		if uri == "" {
+			continue
+		}
+		if !strings.Contains(uri, "://") {
+			uri = "tcp://" + uri
+		}
+		if !contains(ret, uri) {
+			ret = append(ret, uri)
+		}
+	}
+	return ret, nil
+}
+
+// blocklist contains URIs or host names
+func isBlocklisted(uri string, blocklist []string) bool {
+	host := uri
+	if u, err := url.Parse(uri); err == nil {
+		host = u.Hostname()
+	}
+	for _, b := range blocklist {
+		if b == uri || b == host {
+			return true
+		}
+	}
+	return false
+}
+
+func discoverInputs(registry nodeRegistry) {
+	c := cfg.Get().Discovery
+	candidates, err := registry.getCandidates()
+	if err != nil {
+		errorf("Discovery: failed to read node registry: %v", err)
+		return
+	}
+	discoveryMutex.Lock()
+	removeDiscovered__(candidates, c.Blocklist)
+	discoveryMutex.Unlock()
+
+	toProbe := make([]string, 0)
+	for _, uri := range candidates {
+		if isAllowedToDiscover(uri, c.Blocklist) {
+			toProbe = append(toProbe, uri)
+		}
+	}
+	free := c.MaxInputs - inputpart.NumInputs()
+	if free <= 0 || len(toProbe) == 0 {
+		debugf("Discovery: %d candidates, %d new, %d inputs can be added", len(candidates), len(toProbe), free)
+		return
+	}
+	// probing takes time, admin API may remove discovered inputs meanwhile
+	responded := probeCandidates(toProbe, free, time.Duration(c.ProbeTimeoutSec)*time.Second)
+
+	discoveryMutex.Lock()
+	defer discoveryMutex.Unlock()
+	var numAdded int
+	for _, uri := range responded {
+		// inputs could be added or removed while probing
+		if !isAllowedToDiscover(uri, c.Blocklist) || inputpart.NumInputs() >= c.MaxInputs {
+			continue
+		}
+		if err := inputpart.AddInput(uri, "zmq"); err != nil {
+			errorf("Discovery: %v", err)
+			continue
+		}
+		discoveredInputs[uri] = struct{}{}
+		numAdded++
+	}
+	validateQuorums("Discovery")
+	infof("Discovery: %d candidates, probed %d, added %d inputs. Total discovered inputs: %d",
+		len(candidates), len(toProbe), numAdded, len(discoveredInputs))
+}
+
+func isAllowedToDiscover(uri string, blocklist []string) bool {
+	return !isBlocklisted(uri, blocklist) && !isRemovedInOverlay(uri) && !isPruned(uri) && !inputpart.InputExists(uri)
+}
+
+// discovery is turned off: discovered inputs are removed
+func removeAllDiscovered() {
+	discoveryMutex.Lock()
+	defer discoveryMutex.Unlock()
+	if len(discoveredInputs) == 0 {
+		return
+	}
+	infof("Discovery is off: removing %d discovered inputs", len(discoveredInputs))
+	removeDiscovered__(nil, nil)
+	validateQuorums("Discovery")
+}
+
+// removes discovered inputs which are no longer in the registry or are blocklisted.
+// Inputs which were moved to the config file are no longer treated as discovered
+func removeDiscovered__(candidates []string, blocklist []string) {
+	inputsZMQ, inputsNanomsg := getEffectiveInputs(cfg.Get())
+	for uri := range discoveredInputs {
+		if contains(inputsZMQ, uri) || contains(inputsNanomsg, uri) {
+			delete(discoveredInputs, uri)
+			continue
+		}
+		if contains(candidates, uri) && !isBlocklisted(uri, blocklist) {
+			continue
+		}
+		if err := checkQuorumsWithout(uri); err != nil {
+			// will be tried again next time
+			errorf("Discovery: %v", err)
+			continue
+		}
+		if err := inputpart.RemoveInput(uri); err != nil {
+			errorf("Discovery: %v", err)
+		} else {
+			infof("Discovery: removed input '%v'", uri)
+		}
+		delete(discoveredInputs, uri)
+	}
+}
+
+// returns true if input was discovered
+func forgetDiscovered(uri string) bool {
+	discoveryMutex.Lock()
+	defer discoveryMutex.Unlock()
+	return forgetDiscovered__(uri)
+}
+
+func forgetDiscovered__(uri string) bool {
+	_, ok := discoveredInputs[uri]
+	delete(discoveredInputs, uri)
+	return ok
+}
+
+// probes candidates concurrently and returns up to 'limit' of them which responded, in order of the registry
+func probeCandidates(uris []string, limit int, timeout time.Duration) []string {
+	ok := make([]bool, len(uris))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, discoveryMaxProbes)
+	for i := range uris {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := inputpart.ProbeZmqInput(uris[i], timeout); err != nil {
+				debugf("Discovery: probe failed: %v", err)
+				return
+			}
+			ok[i] = true
+		}(i)
+	}
+	wg.Wait()
+
+	ret := make([]string, 0, limit)
+	for i, uri := range uris {
+		if ok[i] && len(ret) < limit {
+			ret = append(ret, uri)
+		}
+	}
+	return ret
+}
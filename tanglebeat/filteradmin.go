@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
+	"net/http"
+)
+
+// GET /api1/admin/filters/list
+// POST /api1/admin/filters/<add|remove>?topic=<filter>
+func adminFiltersHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdminAuthorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	op := r.URL.Path[len("/api1/admin/filters/"):]
+	if op == "list" {
+		data, err := json.MarshalIndent(inputpart.GetOutFilters(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST expected", http.StatusMethodNotAllowed)
+		return
+	}
+	topic := r.FormValue("topic")
+	var err error
+	switch op {
+	case "add":
+		err = inputpart.AddOutFilter(topic)
+	case "remove":
+		err = inputpart.RemoveOutFilter(topic)
+	default:
+		http.Error(w, fmt.Sprintf("wrong operation '%v'", op), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errorf("Admin API: filter %v '%v': %v", op, topic, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	infof("Admin API: filter %v '%v': ok", op, topic)
+	_, _ = fmt.Fprintf(w, "ok\n")
+}
@@ -0,0 +1,188 @@
+package ha
+
+import (
+	"fmt"
+	. "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// active/standby pair of tanglebeat instances.
+// Both instances share the lease file: '<instance id> <unix time ms>'. The leader renews the lease every second.
+// Standby takes over when the lease is not renewed for the lease period. The lease file may be on the shared
+// file system, then clocks of hosts must be in sync.
+// Both instances read inputs and keep caches and metrics warm. Only the leader publishes output streams
+// and exposes metrics. Standby exposes only 'tanglebeat_ha_leader'.
+// Leader which stalled (e.g. on the file system) and didn't renew the lease for the lease period
+// stops publishing at once, without waiting for the next check of the lease.
+// Without the lease file the instance is always the leader
+
+const (
+	leaseCheckPeriod   = 1 * time.Second
+	leaseConfirmPeriod = 200 * time.Millisecond
+)
+
+var (
+	leader          int32 = 1
+	instanceId      string
+	leaseFile       string
+	leasePeriod     time.Duration
+	leaderGauge     Gauge
+	leaseWrittenAt  atomic.Value // time.Time of the last lease written by this instance
+	standbyRegistry = NewRegistry()
+)
+
+func init() {
+	leaderGauge = NewGauge(GaugeOpts{
+		Name: "tanglebeat_ha_leader",
+		Help: "1 if the instance is the leader of active/standby pair, 0 if standby",
+	})
+	MustRegister(leaderGauge)
+	standbyRegistry.MustRegister(leaderGauge)
+	leaderGauge.Set(1)
+}
+
+func IsLeader() bool {
+	if atomic.LoadInt32(&leader) == 0 {
+		return false
+	}
+	if leaseFile == "" {
+		return true
+	}
+	// standby takes over when the lease is older than lease period
+	writtenAt, _ := leaseWrittenAt.Load().(time.Time)
+	return time.Since(writtenAt) < leasePeriod
+}
+
+func GetRole() string {
+	if IsLeader() {
+		return "leader"
+	}
+	return "standby"
+}
+
+// Start checks the lease once and starts lease loop. Instance is the leader if lease file is empty
+func Start(id string, fname string, lease time.Duration) {
+	if fname == "" {
+		return
+	}
+	instanceId, leaseFile, leasePeriod = id, fname, lease
+	setLeader(false)
+	checkLease()
+	go func() {
+		for {
+			time.Sleep(leaseCheckPeriod)
+			checkLease()
+		}
+	}()
+	infof("HA: started with lease file '%v', lease %v. Instance '%v' is %v", leaseFile, leasePeriod, instanceId, GetRole())
+}
+
+// Release removes the lease file if held by this instance, so standby can take over immediately
+func Release() {
+	if leaseFile == "" || !IsLeader() {
+		return
+	}
+	setLeader(false)
+	if id, _, err := readLease(); err == nil && id == instanceId {
+		if err = os.Remove(leaseFile); err != nil {
+			errorf("HA: %v", err)
+		}
+	}
+}
+
+func setLeader(isLeader bool) {
+	var v int32
+	if isLeader {
+		v = 1
+	}
+	if atomic.SwapInt32(&leader, v) != v {
+		if leaseFile != "" {
+			infof("HA: instance '%v' is now %v", instanceId, GetRole())
+		}
+		leaderGauge.Set(float64(v))
+	}
+}
+
+func checkLease() {
+	id, ts, err := readLease()
+	switch {
+	case err == nil && id != instanceId && time.Since(ts) < leasePeriod:
+		setLeader(false) // other instance holds the lease
+		return
+	case err != nil && !os.IsNotExist(err):
+		debugf("HA: lease is considered expired: %v", err)
+	}
+	// lease is free, expired or ours
+	if err = writeLease(); err != nil {
+		errorf("HA: failed to write lease file: %v", err)
+		setLeader(false)
+		return
+	}
+	if !IsLeader() {
+		// other standby may have taken the lease at the same time. The last writer wins
+		time.Sleep(leaseConfirmPeriod)
+		if id, _, err = readLease(); err != nil || id != instanceId {
+			return
+		}
+	}
+	setLeader(true)
+}
+
+func readLease() (string, time.Time, error) {
+	data, err := ioutil.ReadFile(leaseFile)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", time.Time{}, fmt.Errorf("wrong lease file '%v'", leaseFile)
+	}
+	ms, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("wrong lease file '%v': %v", leaseFile, err)
+	}
+	return fields[0], time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// temp file and rename, so the other instance never reads partial lease
+func writeLease() error {
+	tmp, err := ioutil.TempFile(filepath.Dir(leaseFile), filepath.Base(leaseFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	nowis := time.Now()
+	_, err = fmt.Fprintf(tmp, "%v %v\n", instanceId, nowis.UnixNano()/int64(time.Millisecond))
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), leaseFile)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	leaseWrittenAt.Store(nowis)
+	return nil
+}
+
+// MetricsHandler exposes all metrics on the leader and only 'tanglebeat_ha_leader' on standby
+func MetricsHandler() http.Handler {
+	all := promhttp.Handler()
+	standby := promhttp.HandlerFor(standbyRegistry, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsLeader() {
+			all.ServeHTTP(w, r)
+		} else {
+			standby.ServeHTTP(w, r)
+		}
+	})
+}
@@ -3,16 +3,35 @@ package hashcache
 import (
 	"github.com/unioproject/tanglebeat/lib/ebuffer"
 	"github.com/unioproject/tanglebeat/lib/utils"
+	"math/bits"
 )
 
+// SourceSet is a bitmap of ids of sources, one bit for each possible byte id
+type SourceSet [4]uint64
+
 type CacheEntry struct {
 	FirstSeen    uint64
 	LastSeen     uint64
 	Visits       byte
 	FirstVisitId byte
+	Weight       float64       // sum of weights of visits
+	Sources      SourceSet     // ids of sources which has seen the hash
+	Evidence     map[byte]byte // visits counted by source id, only for sources which reported evidence
 	Data         interface{}
 }
 
+func (s *SourceSet) Add(id byte) {
+	s[id>>6] |= 1 << (id & 63)
+}
+
+func (s *SourceSet) Contains(id byte) bool {
+	return s[id>>6]&(1<<(id&63)) != 0
+}
+
+func (s *SourceSet) Count() int {
+	return bits.OnesCount64(s[0]) + bits.OnesCount64(s[1]) + bits.OnesCount64(s[2]) + bits.OnesCount64(s[3])
+}
+
 type cacheSegment struct {
 	ebuffer.ExpiringSegmentBase
 	themap map[string]CacheEntry
@@ -43,16 +62,27 @@ func NewHashCacheBase(id string, hashLen int, segmentDurationSec int, retentionP
 	}
 }
 
+func (cache *HashCacheBase) SetRetentionPeriodSec(retentionPeriodSec int) {
+	cache.ExpiringBuffer.SetRetentionPeriodSec(retentionPeriodSec)
+	cache.Lock()
+	defer cache.Unlock()
+	cache.retentionPeriodMsCopy = uint64(retentionPeriodSec * 1000)
+}
+
+// args: shorthash, id, data, weight, ts
 func (seg *cacheSegment) Put(args ...interface{}) {
 	shorthash := args[0].(string)
-	nowis := utils.UnixMsNow()
-	seg.themap[shorthash] = CacheEntry{
+	nowis := args[4].(uint64)
+	entry := CacheEntry{
 		FirstSeen:    nowis,
 		LastSeen:     nowis,
 		Visits:       1,
 		FirstVisitId: args[1].(byte),
+		Weight:       args[3].(float64),
 		Data:         args[2],
 	}
+	entry.Sources.Add(entry.FirstVisitId)
+	seg.themap[shorthash] = entry
 }
 
 func (seg *cacheSegment) Size() int {
@@ -67,26 +97,85 @@ func (seg *cacheSegment) FindNoTouch(shorthash string, ret *CacheEntry) bool {
 	return seg.findIntern(shorthash, ret, false)
 }
 
-// searches for the hash, marks if found
+// searches for the hash, marks if found. Visits are counted only by sources, see visit
 func (seg *cacheSegment) findIntern(shorthash string, ret *CacheEntry, touch bool) bool {
 	entry, ok := seg.themap[shorthash]
 	if !ok {
 		return false
 	}
 	if touch {
-		seg.themap[shorthash] = CacheEntry{
-			FirstSeen: entry.FirstSeen,
-			LastSeen:  utils.UnixMsNow(),
-			Visits:    entry.Visits + 1,
-			Data:      entry.Data,
-		}
+		entry.LastSeen = utils.UnixMsNow()
+		seg.themap[shorthash] = entry
 	}
 	if ret != nil {
-		*ret = seg.themap[shorthash]
+		*ret = entry
 	}
 	return true
 }
 
+// visit of the hash by the source. Repeated visits by the same source are not counted
+// Data is stored if the entry has none yet
+// returns found, duplicate
+func (seg *cacheSegment) visit(shorthash string, id byte, weight float64, data interface{}, ret *CacheEntry, nowis uint64) (bool, bool) {
+	entry, ok := seg.themap[shorthash]
+	if !ok {
+		return false, false
+	}
+	duplicate := entry.Sources.Contains(id)
+	storeData := entry.Data == nil && data != nil
+	if storeData {
+		entry.Data = data
+	}
+	if !duplicate {
+		entry.LastSeen = nowis
+		entry.Visits++
+		entry.Weight += weight
+		entry.Sources.Add(id)
+	}
+	if !duplicate || storeData {
+		seg.themap[shorthash] = entry
+	}
+	if ret != nil {
+		*ret = entry
+	}
+	return true, duplicate
+}
+
+// evidence of the source counts as that many visits. Only increase over already counted is added
+// returns found, visits added
+func (seg *cacheSegment) addEvidence(shorthash string, id byte, evidence byte, weight float64, ret *CacheEntry, nowis uint64) (bool, byte) {
+	entry, ok := seg.themap[shorthash]
+	if !ok {
+		return false, 0
+	}
+	var counted, added byte
+	if entry.Sources.Contains(id) {
+		counted = 1
+		if e, ok := entry.Evidence[id]; ok {
+			counted = e
+		}
+	}
+	if evidence > counted {
+		added = evidence - counted
+		if added > 255-entry.Visits {
+			added = 255 - entry.Visits
+		}
+		entry.LastSeen = nowis
+		entry.Visits += added
+		entry.Weight += float64(added) * weight
+		entry.Sources.Add(id)
+		if entry.Evidence == nil {
+			entry.Evidence = make(map[byte]byte)
+		}
+		entry.Evidence[id] = evidence
+		seg.themap[shorthash] = entry
+	}
+	if ret != nil {
+		*ret = entry
+	}
+	return true, added
+}
+
 func (seg *cacheSegment) FindWithDelete(shorthash string, ret *CacheEntry) bool {
 	entry, ok := seg.themap[shorthash]
 	if !ok {
@@ -110,10 +199,10 @@ func (cache *HashCacheBase) ShortHash(hash string) string {
 }
 
 func (cache *HashCacheBase) InsertNewNolock(shorthash string, id byte, data interface{}) {
-	cache.NewEntry(shorthash, id, data)
+	cache.NewEntry(shorthash, id, data, float64(1), utils.UnixMsNow())
 }
 
-// finds entry and increases visit counter if found
+// finds entry and updates last seen time if found
 func (cache *HashCacheBase) FindNolock(shorthash string, ret *CacheEntry, touch bool) bool {
 	var found bool
 	if touch {
@@ -167,29 +256,86 @@ func (cache *HashCacheBase) FindWithDelete(hash string, ret *CacheEntry) bool {
 	return cache.__findWithDelete(shash, ret)
 }
 
-// TODO if same message is coming several times from same source it is passed.
-//  It is incorrect but probably not important practically
+func (cache *HashCacheBase) visitNolock(shorthash string, id byte, weight float64, data interface{}, ret *CacheEntry, nowis uint64) (bool, bool) {
+	var found, duplicate bool
+	cache.ForEachSegment__(func(seg ebuffer.ExpiringSegment) bool {
+		found, duplicate = seg.(*cacheSegment).visit(shorthash, id, weight, data, ret, nowis)
+		return !found // stop traversing when found
+	})
+	return found, duplicate
+}
+
+func (cache *HashCacheBase) addEvidenceNolock(shorthash string, id byte, evidence byte, weight float64, ret *CacheEntry, nowis uint64) (bool, byte) {
+	var found bool
+	var added byte
+	cache.ForEachSegment__(func(seg ebuffer.ExpiringSegment) bool {
+		found, added = seg.(*cacheSegment).addEvidence(shorthash, id, evidence, weight, ret, nowis)
+		return !found // stop traversing when found
+	})
+	return found, added
+}
 
+// Visits count distinct sources: if same message is coming several times from same source,
+// only first time is counted
 func (cache *HashCacheBase) SeenHashBy(hash string, id byte, data interface{}, ret *CacheEntry) bool {
+	seen, _ := cache.SeenHashByWeighted(hash, id, 1, data, ret)
+	return seen
+}
+
+// same as SeenHashBy. Each visit by the new source adds the weight of the source to the entry
+// returns seen, duplicate. Duplicate means the hash was already seen from the same source
+func (cache *HashCacheBase) SeenHashByWeighted(hash string, id byte, weight float64, data interface{}, ret *CacheEntry) (bool, bool) {
+	return cache.SeenHashByWeightedAt(hash, id, weight, data, ret, utils.UnixMsNow())
+}
+
+// same as SeenHashByWeighted with the time of the visit given, unix ms. Used by replay of recorded messages
+func (cache *HashCacheBase) SeenHashByWeightedAt(hash string, id byte, weight float64, data interface{}, ret *CacheEntry, nowis uint64) (bool, bool) {
 	cache.Lock()
 	defer cache.Unlock()
 
 	shash := cache.ShortHash(hash)
-	if seen := cache.FindNolock(shash, ret, true); seen {
-		return true
+	if seen, duplicate := cache.visitNolock(shash, id, weight, data, ret, nowis); seen {
+		return true, duplicate
 	}
-	cache.InsertNewNolock(shash, id, data)
+	cache.NewEntry(shash, id, data, weight, nowis)
 	// if new entry, ret is not touched
 	// CacheEntry is mock
 	if ret != nil {
-		nowis := utils.UnixMsNow()
 		ret.Visits = 1
 		ret.LastSeen = nowis
 		ret.FirstSeen = nowis
 		ret.Data = data
 		ret.FirstVisitId = id
+		ret.Weight = weight
+		ret.Sources = SourceSet{}
+		ret.Sources.Add(id)
+	}
+	return false, false
+}
+
+// AddEvidence accounts evidence of the source which stands for several visits, for example upstream tanglebeat
+// which reports the hash was seen by 'evidence' of its inputs. The weight of the source is added for each visit.
+// Repeated evidence of the same source is counted only by the increase, plain visit of the source counts as 1.
+// Returns number of visits added
+func (cache *HashCacheBase) AddEvidence(hash string, id byte, evidence byte, weight float64, ret *CacheEntry) byte {
+	return cache.AddEvidenceAt(hash, id, evidence, weight, ret, utils.UnixMsNow())
+}
+
+// same as AddEvidence with the time of the visit given, unix ms
+func (cache *HashCacheBase) AddEvidenceAt(hash string, id byte, evidence byte, weight float64, ret *CacheEntry, nowis uint64) byte {
+	if evidence == 0 {
+		return 0
+	}
+	cache.Lock()
+	defer cache.Unlock()
+
+	shash := cache.ShortHash(hash)
+	if found, added := cache.addEvidenceNolock(shash, id, evidence, weight, ret, nowis); found {
+		return added
 	}
-	return false
+	cache.NewEntry(shash, id, nil, weight, nowis)
+	_, added := cache.addEvidenceNolock(shash, id, evidence, weight, ret, nowis)
+	return added + 1
 }
 
 type hashcacheStats struct {
@@ -0,0 +1,85 @@
+package hashcache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/unioproject/tanglebeat/lib/ebuffer"
+)
+
+// snapshot of the cache is used for warm restart.
+// Concrete types of CacheEntry.Data must be registered with gob.Register by users of the cache
+
+type segmentSnapshot struct {
+	Created   uint64
+	LastTouch uint64
+	Entries   map[string]CacheEntry
+}
+
+type cacheSnapshot struct {
+	Id       string
+	Segments []segmentSnapshot // newest first
+}
+
+// SaveSnapshot writes all not expired segments of the cache to the encoder.
+// Cache is locked while encoding because Data may be changed by users of the cache under the lock
+func (cache *HashCacheBase) SaveSnapshot(enc *gob.Encoder) error {
+	snap := cacheSnapshot{
+		Id:       cache.GetID(),
+		Segments: make([]segmentSnapshot, 0),
+	}
+	cache.Lock()
+	defer cache.Unlock()
+
+	cache.ForEachSegment__(func(s ebuffer.ExpiringSegment) bool {
+		seg := s.(*cacheSegment)
+		created, lastTouch := seg.GetTimes()
+		snap.Segments = append(snap.Segments, segmentSnapshot{
+			Created:   created,
+			LastTouch: lastTouch,
+			Entries:   seg.themap,
+		})
+		return true
+	})
+	if err := enc.Encode(&snap); err != nil {
+		return fmt.Errorf("failed to save snapshot of '%v': %v", snap.Id, err)
+	}
+	return nil
+}
+
+// LoadSnapshot replaces content of the cache with the snapshot read from the decoder.
+// Segments already past retention period are discarded
+// Returns number of segments and entries restored
+func (cache *HashCacheBase) LoadSnapshot(dec *gob.Decoder) (int, int, error) {
+	var snap cacheSnapshot
+	if err := dec.Decode(&snap); err != nil {
+		return 0, 0, fmt.Errorf("failed to load snapshot of '%v': %v", cache.GetID(), err)
+	}
+	if snap.Id != cache.GetID() {
+		return 0, 0, fmt.Errorf("snapshot of '%v' found instead of '%v'", snap.Id, cache.GetID())
+	}
+	segs := make([]ebuffer.ExpiringSegment, 0, len(snap.Segments))
+	for _, s := range snap.Segments {
+		seg := segmentConstructor(nil).(*cacheSegment)
+		seg.SetTimes(s.Created, s.LastTouch)
+		if s.Entries != nil {
+			seg.themap = s.Entries
+		}
+		segs = append(segs, seg)
+	}
+	cache.Lock()
+	defer cache.Unlock()
+
+	numSeg := cache.RestoreSegments__(segs)
+	var numEntries int
+	for i := 0; i < numSeg; i++ {
+		numEntries += segs[i].Size()
+	}
+	return numSeg, numEntries, nil
+}
+
+// Clear removes all entries of the cache, for example when the snapshot can't be loaded completely
+func (cache *HashCacheBase) Clear() {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.RestoreSegments__(nil)
+}
@@ -0,0 +1,66 @@
+package hashcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func Test_SnapshotRoundTrip(t *testing.T) {
+	cache := NewHashCacheBase("testcache", 0, 10, 60)
+	cache.SeenHashBy("HASH1", 1, []byte("tx HASH1"), nil)
+	cache.SeenHashByWeighted("HASH1", 2, 0.5, nil, nil)
+	cache.SeenHashBy("HASH2", 3, nil, nil)
+	cache.AddEvidence("HASH2", 4, 3, 1, nil)
+
+	var buf bytes.Buffer
+	if err := cache.SaveSnapshot(gob.NewEncoder(&buf)); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	restored := NewHashCacheBase("testcache", 0, 10, 60)
+	numSeg, numEntries, err := restored.LoadSnapshot(gob.NewDecoder(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if numSeg != 1 || numEntries != 2 {
+		t.Errorf("expected 1 segment and 2 entries, got %v and %v", numSeg, numEntries)
+	}
+
+	var entry CacheEntry
+	if !restored.FindNoTouch("HASH1", &entry) {
+		t.Fatalf("HASH1 not restored")
+	}
+	if entry.Visits != 2 || entry.Weight != 1.5 || entry.FirstVisitId != 1 {
+		t.Errorf("HASH1: wrong entry %+v", entry)
+	}
+	if !entry.Sources.Contains(1) || !entry.Sources.Contains(2) || entry.Sources.Count() != 2 {
+		t.Errorf("HASH1: wrong sources %v", entry.Sources)
+	}
+	if data, ok := entry.Data.([]byte); !ok || string(data) != "tx HASH1" {
+		t.Errorf("HASH1: wrong data %v", entry.Data)
+	}
+	if !restored.FindNoTouch("HASH2", &entry) {
+		t.Fatalf("HASH2 not restored")
+	}
+	if entry.Visits != 4 || entry.Evidence[4] != 3 {
+		t.Errorf("HASH2: wrong entry %+v", entry)
+	}
+
+	// sources are tracked after restore: repeated visit is a duplicate, evidence counts only the increase
+	if _, duplicate := restored.SeenHashByWeighted("HASH1", 2, 0.5, nil, nil); !duplicate {
+		t.Errorf("HASH1: repeated visit of source 2 is expected to be duplicate")
+	}
+	if added := restored.AddEvidence("HASH2", 4, 4, 1, nil); added != 1 {
+		t.Errorf("HASH2: expected 1 visit added by evidence, got %v", added)
+	}
+
+	wrong := NewHashCacheBase("othercache", 0, 10, 60)
+	if _, _, err = wrong.LoadSnapshot(gob.NewDecoder(bytes.NewReader(buf.Bytes()))); err == nil {
+		t.Errorf("expected error when loading snapshot of another cache")
+	}
+
+	restored.Clear()
+	if restored.FindNoTouch("HASH1", nil) {
+		t.Errorf("cache is not empty after Clear")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// changes of the input list made at runtime through admin API.
+// Saved to the overlay file and applied on top of the config file upon start
+
+type inputsOverlay struct {
+	AddedZMQ     []string `yaml:"addedZMQ"`
+	AddedNanomsg []string `yaml:"addedNanomsg"`
+	Removed      []string `yaml:"removed"`
+	Paused       []string `yaml:"paused"`
+}
+
+var (
+	overlay      inputsOverlay
+	overlayMutex sync.Mutex
+)
+
+func contains(lst []string, s string) bool {
+	for _, e := range lst {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func without(lst []string, s string) []string {
+	ret := make([]string, 0, len(lst))
+	for _, e := range lst {
+		if e != s {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func loadInputsOverlay() {
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+
+	fname := cfg.Config.InputsOverlayFile
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			errorf("Failed to read overlay file '%v': %v", fname, err)
+		}
+		return
+	}
+	if err = yaml.Unmarshal(data, &overlay); err != nil {
+		errorf("Failed to parse overlay file '%v', ignoring it: %v", fname, err)
+		overlay = inputsOverlay{}
+		return
+	}
+	infof("Inputs overlay '%v' loaded: added ZMQ %d, added Nanomsg %d, removed %d, paused %d",
+		fname, len(overlay.AddedZMQ), len(overlay.AddedNanomsg), len(overlay.Removed), len(overlay.Paused))
+}
+
+func saveInputsOverlay__() error {
+	data, err := yaml.Marshal(&overlay)
+	if err == nil {
+		err = ioutil.WriteFile(cfg.Config.InputsOverlayFile, data, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("change applied but failed to save overlay file '%v': %v", cfg.Config.InputsOverlayFile, err)
+	}
+	return nil
+}
+
+// inputs from the config with overlay applied
+func getEffectiveInputs(c *cfg.ConfigStructYAML) ([]string, []string) {
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+
+	return applyOverlay__(c.IriMsgStream.InputsZMQ, overlay.AddedZMQ),
+		applyOverlay__(c.IriMsgStream.InputsNanomsg, overlay.AddedNanomsg)
+}
+
+func applyOverlay__(fromConfig, added []string) []string {
+	ret := make([]string, 0, len(fromConfig)+len(added))
+	for _, lst := range [][]string{fromConfig, added} {
+		for _, uri := range lst {
+			if !contains(overlay.Removed, uri) && !contains(ret, uri) {
+				ret = append(ret, uri)
+			}
+		}
+	}
+	return ret
+}
+
+func pauseInputsFromOverlay() {
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+	for _, uri := range overlay.Paused {
+		if err := inputpart.PauseInput(uri); err != nil {
+			errorf("Overlay: %v", err)
+		}
+	}
+}
+
+// SN and LMI quorums must be reachable with inputs left after removal of the input
+func checkQuorumsWithout(uri string) error {
+	if err := cfg.ValidateQuorums(cfg.Get(), inputpart.NumQuorumInputs(uri)); err != nil {
+		return fmt.Errorf("can't remove input '%v': %v", uri, err)
+	}
+	return nil
+}
+
+// input list was changed at runtime
+func validateQuorums(who string) {
+	if err := cfg.ValidateQuorums(cfg.Get(), inputpart.NumQuorumInputs("")); err != nil {
+		errorf("%v: %v", who, err)
+	}
+}
+
+// quorums of the config are checked against its inputs at start and upon reload.
+// Number of sources in replayed files and of discovered inputs is not known in advance,
+// with replay or discovery quorums are only checked at runtime
+func checkConfigQuorums(c *cfg.ConfigStructYAML) error {
+	if len(c.IriMsgStream.InputsReplay) > 0 || getNodeRegistry(&c.Discovery) != nil {
+		return nil
+	}
+	inputsZMQ, inputsNanomsg := getEffectiveInputs(c)
+	return cfg.ValidateQuorums(c, len(inputsZMQ)+len(inputsNanomsg)+len(c.Federation.Upstreams))
+}
+
+func adminAddInput(uri, protocol string) error {
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+
+	if err := inputpart.AddInput(uri, protocol); err != nil {
+		return err
+	}
+	validateQuorums("Admin API")
+	forgetPruned(uri)
+	overlay.Removed = without(overlay.Removed, uri)
+	inConfig := isInConfig(uri)
+	if !inConfig {
+		if strings.ToLower(protocol) == "nanomsg" {
+			overlay.AddedNanomsg = append(overlay.AddedNanomsg, uri)
+		} else {
+			overlay.AddedZMQ = append(overlay.AddedZMQ, uri)
+		}
+	}
+	return saveInputsOverlay__()
+}
+
+func adminRemoveInput(uri string) error {
+	// same lock order as in discovery
+	discoveryMutex.Lock()
+	defer discoveryMutex.Unlock()
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+
+	if err := checkQuorumsWithout(uri); err != nil {
+		return err
+	}
+	if err := inputpart.RemoveInput(uri); err != nil {
+		return err
+	}
+	// removed discovered input must not be discovered again
+	discovered := forgetDiscovered__(uri)
+	validateQuorums("Admin API")
+	overlay.AddedZMQ = without(overlay.AddedZMQ, uri)
+	overlay.AddedNanomsg = without(overlay.AddedNanomsg, uri)
+	overlay.Paused = without(overlay.Paused, uri)
+	inConfig := isInConfig(uri)
+	if (inConfig || discovered) && !contains(overlay.Removed, uri) {
+		overlay.Removed = append(overlay.Removed, uri)
+	}
+	return saveInputsOverlay__()
+}
+
+func adminPauseInput(uri string) error {
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+
+	if err := inputpart.PauseInput(uri); err != nil {
+		return err
+	}
+	if !contains(overlay.Paused, uri) {
+		overlay.Paused = append(overlay.Paused, uri)
+	}
+	return saveInputsOverlay__()
+}
+
+func adminResumeInput(uri string) error {
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+
+	if err := inputpart.ResumeInput(uri); err != nil {
+		return err
+	}
+	overlay.Paused = without(overlay.Paused, uri)
+	return saveInputsOverlay__()
+}
+
+// token is expected in the header 'Authorization: Bearer <token>'
+func isAdminAuthorized(r *http.Request) bool {
+	adminToken := cfg.Get().AdminToken
+	if adminToken == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// input is listed in the config file
+func isInConfig(uri string) bool {
+	c := cfg.Get()
+	return contains(c.IriMsgStream.InputsZMQ, uri) || contains(c.IriMsgStream.InputsNanomsg, uri)
+}
+
+// POST /api1/admin/inputs/<add|remove|pause|resume>?uri=<uri>[&protocol=<zmq|nanomsg>]
+func adminInputsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdminAuthorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST expected", http.StatusMethodNotAllowed)
+		return
+	}
+	op := r.URL.Path[len("/api1/admin/inputs/"):]
+	uri := r.FormValue("uri")
+	if uri == "" {
+		http.Error(w, "parameter 'uri' is missing", http.StatusBadRequest)
+		return
+	}
+	var err error
+	switch op {
+	case "add":
+		err = adminAddInput(uri, r.FormValue("protocol"))
+	case "remove":
+		err = adminRemoveInput(uri)
+	case "pause":
+		err = adminPauseInput(uri)
+	case "resume":
+		err = adminResumeInput(uri)
+	default:
+		http.Error(w, fmt.Sprintf("wrong operation '%v'", op), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errorf("Admin API: %v '%v': %v", op, uri, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	infof("Admin API: %v '%v': ok", op, uri)
+	_, _ = fmt.Fprintf(w, "ok\n")
+}
+
+func isRemovedInOverlay(uri string) bool {
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+	return contains(overlay.Removed, uri)
+}
+
+func isPausedInOverlay(uri string) bool {
+	overlayMutex.Lock()
+	defer overlayMutex.Unlock()
+	return contains(overlay.Paused, uri)
+}
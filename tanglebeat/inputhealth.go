@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health report of inputs and removal of chronically bad ones.
+// Input with complete window and health score below 'health.removeBelow' for 'health.removeAfterMin' minutes
+// is removed until restart. Removal is not saved: after restart the input is back and is removed again
+// if it is still bad. Removed discovered input is not discovered again until restart.
+// Inputs are not removed below max('health.minInputs', 'quorumToPass') inputs. Nothing is removed when
+// most inputs are bad at once: the problem is likely on our side (network, clock), not with the nodes.
+// Auto removal is disabled if 'health.removeBelow' is 0
+
+const healthPruneCheckPeriod = 1 * time.Minute
+
+var (
+	prunedInputs = make(map[string]struct{})
+	prunedMutex  sync.Mutex
+)
+
+func startHealthPruning() {
+	go healthPruneLoop()
+}
+
+func healthPruneLoop() {
+	for {
+		time.Sleep(healthPruneCheckPeriod)
+		// config may be reloaded
+		if c := cfg.Get(); c.Health.RemoveBelow > 0 {
+			pruneInputs(c)
+		}
+	}
+}
+
+func pruneInputs(c *cfg.ConfigStructYAML) {
+	removeAfterMs := uint64(c.Health.RemoveAfterMin) * 60 * 1000
+	report := inputpart.GetInputHealth()
+	var numBelow int
+	toRemove := make([]*inputpart.InputHealth, 0)
+	for _, h := range report {
+		if h.BelowThresholdTs == 0 {
+			continue
+		}
+		numBelow++
+		if utils.SinceUnixMs(h.BelowThresholdTs) >= removeAfterMs && inputpart.InputExists(h.Uri) {
+			toRemove = append(toRemove, h)
+		}
+	}
+	if len(toRemove) == 0 {
+		return
+	}
+	if 2*numBelow > len(report) {
+		warningf("Health: %d of %d inputs have health score below %v. Not removing any of them",
+			numBelow, len(report), c.Health.RemoveBelow)
+		return
+	}
+	minInputs := c.Health.MinInputs
+	if minInputs < c.QuorumTxToPass {
+		minInputs = c.QuorumTxToPass
+	}
+	for _, h := range toRemove {
+		if inputpart.NumQuorumInputs(h.Uri) < minInputs {
+			debugf("Health: input '%v' has health score %v < %v, but is not removed: at least %v inputs must be left",
+				h.Uri, h.Score, c.Health.RemoveBelow, minInputs)
+			continue
+		}
+		warningf("Input '%v' has health score %v < %v for more than %v min. Removing it until restart",
+			h.Uri, h.Score, c.Health.RemoveBelow, c.Health.RemoveAfterMin)
+		if err := pruneInput(h.Uri); err != nil {
+			errorf("Failed to remove input '%v': %v", h.Uri, err)
+		}
+	}
+	validateQuorums("Health")
+}
+
+// removes input without saving it to the overlay
+func pruneInput(uri string) error {
+	if err := checkQuorumsWithout(uri); err != nil {
+		return err
+	}
+	if err := inputpart.RemoveInput(uri); err != nil {
+		return err
+	}
+	forgetDiscovered(uri)
+	prunedMutex.Lock()
+	defer prunedMutex.Unlock()
+	prunedInputs[uri] = struct{}{}
+	return nil
+}
+
+func isPruned(uri string) bool {
+	prunedMutex.Lock()
+	defer prunedMutex.Unlock()
+	_, ok := prunedInputs[uri]
+	return ok
+}
+
+// input added with admin API is no longer treated as removed by health score
+func forgetPruned(uri string) {
+	prunedMutex.Lock()
+	defer prunedMutex.Unlock()
+	delete(prunedInputs, uri)
+}
+
+// GET /api1/inputs/health[?displayall]. Inputs ranked by health score, best first
+func inputsHealthHandler(w http.ResponseWriter, r *http.Request) {
+	report := inputpart.GetInputHealth()
+	if _, displayAll := r.URL.Query()["displayall"]; !displayAll {
+		for i, h := range report {
+			if utils.IsIpAddr(h.Uri) {
+				tmp := *h
+				tmp.Uri = "IP addr (masked)"
+				report[i] = &tmp
+			}
+		}
+	}
+	data, err := json.MarshalIndent(report, "", "   ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("marshal error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
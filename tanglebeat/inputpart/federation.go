@@ -0,0 +1,219 @@
+package inputpart
+
+import (
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/hashcache"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// federation of tanglebeat instances.
+// Downstream instance reads output streams of upstream instances listed in 'federation.upstreams'.
+// Upstream with 'quorumUpdatesEnabled' publishes 'seen <hash> <n> <path>': n of its inputs have seen the transaction,
+// path is comma separated list of instance ids the update went through, the publisher first.
+// Downstream counts evidence of the upstream as n visits of the transaction (up to 'federation.maxEvidence')
+// instead of one. Upstreams with common ids in their paths may count the same nodes (diamond), so evidence
+// of the upstream is counted as one visit if the transaction already has evidence of such an upstream.
+// Upstream with own instance id in the path is in the loop: its updates and transactions are dropped
+// until its path is clean again.
+// Own 'seen' updates carry own id followed by ids of all known upstream instances.
+// If quorum is reached by evidence before the transaction itself arrived, it is released upon arrival
+
+const (
+	federationMaxPathLen    = 16
+	pendingTxTimeout        = 1 * time.Minute
+	pendingTxSweepThreshold = 1000
+)
+
+var (
+	instanceId        string
+	upstreamPaths     = make(map[string][]string) // uri -> last path received from the upstream
+	upstreamPathsById = make(map[byte][]string)   // same by id of the upstream input
+	federationPath    string                      // path of own 'seen' updates
+	federationMutex   sync.RWMutex
+	pendingTx         = make(map[string]time.Time) // accessed only from the filter loop
+	upstreamTopics    = append(append([]string{}, topics...), "seen")
+	federationEnabled bool
+)
+
+func initFederation() {
+	instanceId = cfg.Config.Federation.InstanceId
+	federationPath = instanceId
+	federationEnabled = len(cfg.Config.Federation.Upstreams) > 0
+	for _, uri := range cfg.Config.Federation.Upstreams {
+		createInputRoutine(uri, inputStreamUpstream)
+	}
+}
+
+func getFederationPath() string {
+	federationMutex.RLock()
+	defer federationMutex.RUnlock()
+	return federationPath
+}
+
+func setUpstreamPath(uri string, id byte, path []string) {
+	federationMutex.Lock()
+	defer federationMutex.Unlock()
+
+	upstreamPaths[uri] = path
+	upstreamPathsById[id] = path
+	ids := make(map[string]struct{})
+	for _, p := range upstreamPaths {
+		for _, id := range p {
+			ids[id] = struct{}{}
+		}
+	}
+	delete(ids, instanceId)
+	lst := make([]string, 0, len(ids))
+	for id := range ids {
+		lst = append(lst, id)
+	}
+	sort.Strings(lst)
+	if len(lst) > federationMaxPathLen-1 {
+		lst = lst[:federationMaxPathLen-1]
+	}
+	federationPath = strings.Join(append([]string{instanceId}, lst...), ",")
+	infof("Federation: path of the upstream '%v' is %v. Own path: %v", uri, path, federationPath)
+}
+
+// true if the upstream has common instance ids with any other upstream which gave evidence for the entry
+func evidenceOverlaps(id byte, entry *hashcache.CacheEntry) bool {
+	federationMutex.RLock()
+	defer federationMutex.RUnlock()
+	path := upstreamPathsById[id]
+	for other := range entry.Evidence {
+		if other == id {
+			continue
+		}
+		for _, a := range upstreamPathsById[other] {
+			for _, b := range path {
+				if a == b {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// data stored with tx in the cache. Only needed to release tx when quorum is reached by evidence
+func fedTxData(msgData []byte) interface{} {
+	if !federationEnabled {
+		return nil
+	}
+	return msgData
+}
+
+// 'seen <hash> <n> <path>' from the upstream
+func filterSeenMsg(routine *inputRoutine, msgData []byte, msgSplit []string, ts uint64) {
+	if len(msgSplit) < 3 {
+		errorf("%v: Message %v is invalid", routine.GetUri(), string(msgData))
+		return
+	}
+	n, err := strconv.Atoi(msgSplit[2])
+	if err != nil || n < 1 {
+		errorf("%v: Message %v is invalid: expected number of times seen", routine.GetUri(), string(msgData))
+		return
+	}
+	var path []string
+	if len(msgSplit) > 3 {
+		path = strings.Split(msgSplit[3], ",")
+	}
+	for _, id := range path {
+		if id == instanceId {
+			routine.accountFederationLoop(path)
+			return
+		}
+	}
+	routine.accountEvidence(path)
+	if routine.IsOutputClosed() {
+		return
+	}
+	if max := cfg.Get().Federation.MaxEvidence; n > max {
+		n = max
+	}
+	if n > 255 {
+		n = 255
+	}
+	var entry hashcache.CacheEntry
+	id := routine.GetId__()
+	if n > 1 && txcache.FindNoTouch(msgSplit[1], &entry) && evidenceOverlaps(id, &entry) {
+		// nodes behind the upstream are already counted
+		n = 1
+	}
+	weight := routine.getWeight()
+	if added := txcache.AddEvidenceAt(msgSplit[1], id, byte(n), weight, &entry, ts); added > 0 {
+		checkTxQuorum(msgSplit[1], nil, nil, &entry, added, weight)
+	}
+}
+
+// quorum was reached by evidence while tx message was not received yet
+func addPendingTx(hash string) {
+	if len(pendingTx) >= pendingTxSweepThreshold {
+		for h, ts := range pendingTx {
+			if time.Since(ts) > pendingTxTimeout {
+				delete(pendingTx, h)
+			}
+		}
+	}
+	pendingTx[hash] = time.Now()
+}
+
+// releases tx message if its quorum was reached by evidence before
+func releasePendingTx(msgData []byte, msgSplit []string) {
+	if len(pendingTx) == 0 {
+		return
+	}
+	if _, ok := pendingTx[msgSplit[1]]; !ok {
+		return
+	}
+	delete(pendingTx, msgSplit[1])
+	toOutput(msgData, msgSplit)
+}
+
+func (r *inputRoutine) accountEvidence(path []string) {
+	r.Lock()
+	if !r.initialized {
+		r.Unlock()
+		return
+	}
+	r.evidenceCount++
+	if r.inLoop {
+		infof("Federation: path of '%v' is %v, loop is gone", r.uri, path)
+		r.inLoop = false
+	}
+	pathStr := strings.Join(path, ",")
+	changed := pathStr != r.upstreamPath
+	r.upstreamPath = pathStr
+	uri := r.uri
+	id := r.GetId__()
+	r.Unlock()
+
+	if changed {
+		setUpstreamPath(uri, id, path)
+	}
+}
+
+func (r *inputRoutine) accountFederationLoop(path []string) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.initialized {
+		return
+	}
+	if !r.inLoop {
+		errorf("Federation: loop detected. Update from '%v' with path %v contains own instance id '%v'. "+
+			"Messages of the upstream are dropped until the loop is gone", r.uri, path, instanceId)
+	}
+	r.inLoop = true
+	r.loopCount++
+}
+
+// messages of the upstream in the loop are own messages coming back, they must not be counted
+func (r *inputRoutine) isInFederationLoop() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.inLoop
+}
@@ -0,0 +1,72 @@
+package inputpart
+
+import (
+	"fmt"
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/hashcache"
+	"github.com/unioproject/tanglebeat/tanglebeat/inreaders"
+	"strings"
+	"testing"
+)
+
+func newUpstreamForTest(uri string, id byte) *inputRoutine {
+	r := &inputRoutine{
+		InputReaderBase:  *inreaders.NewInputReaderBase(),
+		inputStreamType:  inputStreamUpstream,
+		uri:              uri,
+		weight:           1,
+		autoWeightFactor: 1,
+	}
+	r.SetId__(id)
+	r.init()
+	return r
+}
+
+func seenForTest(r *inputRoutine, msg string) {
+	filterSeenMsg(r, []byte(msg), strings.Split(msg, " "), utils.UnixMsNow())
+}
+
+func Test_FederationEvidence(t *testing.T) {
+	initFilterForTest(t)
+	c := new(cfg.ConfigStructYAML)
+	*c = *cfg.Get()
+	c.Federation.MaxEvidence = 5
+	cfg.Set(c)
+	instanceId = "self"
+
+	// A and B both read C: evidence of B stands for the same nodes as evidence of A
+	a := newUpstreamForTest("fed-a", 250)
+	b := newUpstreamForTest("fed-b", 251)
+	d := newUpstreamForTest("fed-d", 252)
+	simRuns++
+	hash := fmt.Sprintf("FED%09dHASH", simRuns)
+	seenForTest(a, "seen "+hash+" 3 A,C")
+	seenForTest(b, "seen "+hash+" 3 B,C")
+	seenForTest(d, "seen "+hash+" 2 D")
+	var entry hashcache.CacheEntry
+	if !txcache.FindNoTouch(hash, &entry) {
+		t.Fatalf("evidence is not in the cache")
+	}
+	if entry.Visits != 6 {
+		t.Errorf("expected 3 + 1 + 2 visits, got %v", entry.Visits)
+	}
+
+	// tx of the upstream after its evidence is not a duplicate
+	msg := "tx " + hash
+	filterTXMsg(a, []byte(msg), strings.Split(msg, " "), utils.UnixMsNow())
+	if a.duplicateCount != 0 {
+		t.Errorf("tx after evidence counted as duplicate")
+	}
+
+	// upstream with own id in the path is in the loop until its path is clean
+	e := newUpstreamForTest("fed-e", 253)
+	seenForTest(e, "seen "+hash+" 3 E,self")
+	if !e.isInFederationLoop() {
+		t.Errorf("loop is not detected")
+	}
+	seenForTest(e, "seen "+hash+" 3 E")
+	if e.isInFederationLoop() {
+		t.Errorf("loop is not cleared")
+	}
+}
@@ -0,0 +1,194 @@
+package inputpart
+
+import (
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rolling health score of inputs, 0 (worst) to 100 (best).
+// Every minute each enabled input is sampled. Score of the sample is sum of:
+// up to 40 for being up (running, waiting for milestone or lagging), up to 15 for no connection errors,
+// up to 15 for milestone lag (0 if 10 or more milestones behind), up to 15 for seen once rate
+// and up to 15 for open output valve.
+// Health score is average of samples over last 'health.windowMin' minutes
+
+const (
+	healthSamplePeriod = 1 * time.Minute
+	healthMaxLag       = 10
+)
+
+type healthSample struct {
+	up          bool
+	failed      bool
+	lmiLag      int
+	seenOnce    uint64
+	valveClosed bool
+	score       float64
+}
+
+type inputHealth struct {
+	samples    []*healthSample
+	belowSince time.Time // when score fell below 'health.removeBelow'. Zero if not below
+}
+
+type InputHealth struct {
+	Uri              string  `json:"uri"`
+	Rank             int     `json:"rank"`
+	Score            float64 `json:"score"`
+	NumSamples       int     `json:"numSamples"`
+	UptimePerc       float64 `json:"uptimePerc"`
+	ErrorSamples     int     `json:"errorSamples"`
+	AvgLmiLag        float64 `json:"avgLmiLag"`
+	AvgSeenOnceRate  float64 `json:"avgSeenOnceRate"`
+	ValveClosedPerc  float64 `json:"valveClosedPerc"`
+	BelowThresholdTs uint64  `json:"belowThresholdSince,omitempty"`
+	// score is valid only when window is full
+	Complete bool `json:"complete"`
+}
+
+var (
+	health      = make(map[string]*inputHealth)
+	healthMutex sync.RWMutex
+)
+
+func startHealthRoutine() {
+	go healthLoop()
+	infof("Started 'healthLoop'")
+}
+
+func healthLoop() {
+	for {
+		time.Sleep(healthSamplePeriod)
+		sampleHealth(GetInputStats())
+	}
+}
+
+func newHealthSample(st *ZmqRoutineStats) *healthSample {
+	ret := &healthSample{
+		failed:      st.ConsecutiveFailures > 0,
+		lmiLag:      st.LmiLag,
+		seenOnce:    st.SeenOnceRate,
+		valveClosed: st.OutputClosed,
+	}
+	switch st.State {
+	case "running", "wait_milestone", "lagging":
+		ret.up = true
+	}
+	if ret.up {
+		ret.score += 40
+	}
+	if !ret.failed {
+		ret.score += 15
+	}
+	if ret.lmiLag < healthMaxLag {
+		ret.score += 15 * float64(healthMaxLag-ret.lmiLag) / healthMaxLag
+	}
+	if ret.seenOnce < 100 {
+		ret.score += 15 * float64(100-ret.seenOnce) / 100
+	}
+	if !ret.valveClosed {
+		ret.score += 15
+	}
+	return ret
+}
+
+func sampleHealth(stats []*ZmqRoutineStats) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	c := cfg.Get().Health
+	windowSize := c.WindowMin
+	newHealth := make(map[string]*inputHealth, len(stats))
+	for _, st := range stats {
+		if st.Disabled {
+			continue // paused
+		}
+		h, ok := health[st.Uri]
+		if !ok {
+			h = &inputHealth{}
+		}
+		newHealth[st.Uri] = h
+		h.samples = append(h.samples, newHealthSample(st))
+		if len(h.samples) > windowSize {
+			h.samples = h.samples[len(h.samples)-windowSize:]
+		}
+		if len(h.samples) < windowSize || h.score() >= c.RemoveBelow {
+			h.belowSince = time.Time{}
+		} else if h.belowSince.IsZero() {
+			h.belowSince = time.Now()
+		}
+	}
+	health = newHealth
+}
+
+func (h *inputHealth) score() float64 {
+	if len(h.samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range h.samples {
+		sum += s.score
+	}
+	return sum / float64(len(h.samples))
+}
+
+func round2(f float64) float64 {
+	return math.Round(100*f) / 100
+}
+
+// GetInputHealth returns health of inputs, best first
+func GetInputHealth() []*InputHealth {
+	healthMutex.RLock()
+	defer healthMutex.RUnlock()
+
+	ret := make([]*InputHealth, 0, len(health))
+	for uri, h := range health {
+		n := len(h.samples)
+		if n == 0 {
+			continue
+		}
+		ih := &InputHealth{
+			Uri:        uri,
+			Score:      round2(h.score()),
+			NumSamples: n,
+			Complete:   n >= cfg.Get().Health.WindowMin,
+		}
+		var up, closed int
+		var lag, seenOnce float64
+		for _, s := range h.samples {
+			if s.up {
+				up++
+			}
+			if s.failed {
+				ih.ErrorSamples++
+			}
+			if s.valveClosed {
+				closed++
+			}
+			lag += float64(s.lmiLag)
+			seenOnce += float64(s.seenOnce)
+		}
+		ih.UptimePerc = round2(100 * float64(up) / float64(n))
+		ih.ValveClosedPerc = round2(100 * float64(closed) / float64(n))
+		ih.AvgLmiLag = round2(lag / float64(n))
+		ih.AvgSeenOnceRate = round2(seenOnce / float64(n))
+		if !h.belowSince.IsZero() {
+			ih.BelowThresholdTs = utils.UnixMs(h.belowSince)
+		}
+		ret = append(ret, ih)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Score != ret[j].Score {
+			return ret[i].Score > ret[j].Score
+		}
+		return ret[i].Uri < ret[j].Uri
+	})
+	for i := range ret {
+		ret[i].Rank = i + 1
+	}
+	return ret
+}
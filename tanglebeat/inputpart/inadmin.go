@@ -0,0 +1,129 @@
+package inputpart
+
+import (
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/inreaders"
+	"strings"
+	"time"
+)
+
+// runtime management of input streams, called from admin API
+
+func protocolToStreamType(protocol string) (int, error) {
+	switch strings.ToLower(protocol) {
+	case "zmq", "":
+		return inputStreamZMQ, nil
+	case "nanomsg":
+		return inputStreamNanomsg, nil
+	}
+	return 0, fmt.Errorf("wrong protocol '%v'. Must be 'zmq' or 'nanomsg'", protocol)
+}
+
+func AddInput(uri string, protocol string) error {
+	if uri == "" {
+		return fmt.Errorf("uri is empty")
+	}
+	typ, err := protocolToStreamType(protocol)
+	if err != nil {
+		return err
+	}
+	if !createInputRoutine(uri, typ) {
+		return fmt.Errorf("can't add input '%v': already exists or too many inputs", uri)
+	}
+	infof("Added input %v '%v'", protocol, uri)
+	return nil
+}
+
+func RemoveInput(uri string) error {
+	if err := inputRoutines.RemoveInputReader(uri); err != nil {
+		return err
+	}
+	deleteDuplicateMsgCounter(uri)
+	infof("Removed input '%v'", uri)
+	return nil
+}
+
+func PauseInput(uri string) error {
+	if err := inputRoutines.DisableInputReader(uri); err != nil {
+		return err
+	}
+	infof("Paused input '%v'", uri)
+	return nil
+}
+
+func ResumeInput(uri string) error {
+	if err := inputRoutines.EnableInputReader(uri); err != nil {
+		return err
+	}
+	infof("Resumed input '%v'", uri)
+	return nil
+}
+
+func InputExists(uri string) bool {
+	_, ok := inputRoutines.Get(uri)
+	return ok
+}
+
+func NumInputs() int {
+	var ret int
+	inputRoutines.ForEach(func(name string, ir inreaders.InputReader) {
+		ret++
+	})
+	return ret
+}
+
+// NumQuorumInputs returns number of ZMQ and Nanomsg inputs, sources of replayed files and upstreams,
+// the ones quorums are validated against. Input 'except' is not counted
+func NumQuorumInputs(except string) int {
+	var ret int
+	inputRoutines.ForEach(func(name string, ir inreaders.InputReader) {
+		switch ir.(*inputRoutine).inputStreamType {
+		case inputStreamZMQ, inputStreamNanomsg, inputStreamReplaySource, inputStreamUpstream:
+			if name != except {
+				ret++
+			}
+		}
+	})
+	return ret
+}
+
+// ProbeZmqInput connects to the ZMQ uri and waits for the first message
+func ProbeZmqInput(uri string, timeout time.Duration) error {
+	socket, err := NewZmqSocket(uri, topics)
+	if err != nil {
+		return err
+	}
+	chErr := make(chan error, 1)
+	go func() {
+		_, _, err := socket.RecvMsg()
+		chErr <- err
+	}()
+	select {
+	case err = <-chErr:
+	case <-time.After(timeout):
+		err = fmt.Errorf("no messages from '%v' in %v", uri, timeout)
+	}
+	// makes RecvMsg return if still waiting
+	socket.Close()
+	return err
+}
+
+// SetRetentionPeriodMin changes retention period of the message caches at runtime
+func SetRetentionPeriodMin(retentionPeriodMin int) {
+	retentionPeriodSec := retentionPeriodMin * 60
+	txcache.SetRetentionPeriodSec(retentionPeriodSec)
+	sncache.SetRetentionPeriodSec(retentionPeriodSec)
+	lmhsCache.SetRetentionPeriodSec(retentionPeriodSec)
+	transferBundleCache.SetRetentionPeriodSec(retentionPeriodSec)
+	inputRoutines.SetIdQuarantine(idQuarantine(retentionPeriodMin))
+}
+
+// id of the removed input stays in the caches until entries expire, the longest segment included.
+// Not reused until then
+func idQuarantine(retentionPeriodMin int) time.Duration {
+	return time.Duration(retentionPeriodMin)*time.Minute + time.Duration(segmentDurationBundleCacheSec)*time.Second
+}
+
+func SetOutputEnabled(enabled bool) error {
+	return compoundOutPublisher.SetEnabled(enabled)
+}
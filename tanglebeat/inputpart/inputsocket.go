@@ -1,15 +1,20 @@
 package inputpart
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-zeromq/zmq4"
+	"github.com/unioproject/tanglebeat/lib/nanomsg"
 	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
 	"nanomsg.org/go-mangos"
 	"nanomsg.org/go-mangos/protocol/sub"
-	"nanomsg.org/go-mangos/transport/tcp"
 	"strings"
+	"time"
 )
 
+const socketCloseTimeout = 5 * time.Second
+
 type inSocket interface {
 	RecvMsg() ([]byte, []string, error)
 	Close()
@@ -18,6 +23,7 @@ type inSocket interface {
 type zmqInSocket struct {
 	uri    string
 	socket zmq4.Socket
+	cancel context.CancelFunc
 }
 
 type nanomsgInSocket struct {
@@ -26,10 +32,12 @@ type nanomsgInSocket struct {
 }
 
 func NewZmqSocket(uri string, topics []string) (inSocket, error) {
-	sock, err := utils.OpenSocketAndSubscribe(uri, topics)
+	ctx, cancel := context.WithCancel(context.Background())
+	sock, err := utils.OpenSocketAndSubscribeCtx(ctx, uri, topics)
 	if err == nil {
-		return &zmqInSocket{uri: uri, socket: sock}, nil
+		return &zmqInSocket{uri: uri, socket: sock, cancel: cancel}, nil
 	}
+	cancel()
 	return nil, err
 }
 
@@ -38,8 +46,9 @@ func NewNanomsgSocket(uri string, topics []string) (inSocket, error) {
 	if err != nil {
 		return nil, fmt.Errorf("can't create new Mangos sub socket for %v: %v", uri, err)
 	}
-	sock.AddTransport(tcp.NewTransport())
-	if err = sock.Dial(uri); err != nil {
+	// 'tls+tcp://' or 'tcp://'
+	if err = nanomsg.Dial(sock, uri, &cfg.Config.TLS.Client); err != nil {
+		_ = sock.Close()
 		return nil, fmt.Errorf("can't dial sub socket for %v: %v", uri, err)
 	}
 	for _, tpc := range topics {
@@ -64,11 +73,10 @@ func (s *zmqInSocket) RecvMsg() ([]byte, []string, error) {
 	return msg.Frames[0], msgSplit, nil
 }
 
+// Close cancels the context of the socket, so pending RecvMsg returns, then closes the socket
 func (s *zmqInSocket) Close() {
-	// better leak go routine than block or leak socket
-	go func() {
-		_ = s.socket.Close()
-	}()
+	s.cancel()
+	closeSocket(s.uri, s.socket.Close)
 }
 
 func (s *nanomsgInSocket) RecvMsg() ([]byte, []string, error) {
@@ -85,8 +93,22 @@ func (s *nanomsgInSocket) RecvMsg() ([]byte, []string, error) {
 }
 
 func (s *nanomsgInSocket) Close() {
-	// better leak go routine than block or leak socket
+	closeSocket(s.uri, s.socket.Close)
+}
+
+// waits until socket is closed, but not longer than 'socketCloseTimeout'.
+// Socket which can't be closed in time is reported and left closing in the background
+func closeSocket(uri string, closeFun func() error) {
+	done := make(chan error, 1)
 	go func() {
-		_ = s.socket.Close()
+		done <- closeFun()
 	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			debugf("Closing socket for '%v': %v", uri, err)
+		}
+	case <-time.After(socketCloseTimeout):
+		errorf("Closing socket for '%v' takes longer than %v", uri, socketCloseTimeout)
+	}
 }
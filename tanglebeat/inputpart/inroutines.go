@@ -5,6 +5,7 @@ import (
 	"github.com/unioproject/tanglebeat/lib/ebuffer"
 	"github.com/unioproject/tanglebeat/lib/nanomsg"
 	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
 	"github.com/unioproject/tanglebeat/tanglebeat/inreaders"
 	"math"
 	"sort"
@@ -15,11 +16,15 @@ const (
 	tlTXCacheSegmentDurationSec = 10
 	tlSNCacheSegmentDurationSec = 60
 	routineBufferRetentionMin   = 5
+	silenceCheckPeriod          = 5 * time.Second
 )
 
 const (
-	inputStreamZMQ     = 0
-	inputStreamNanomsg = 1
+	inputStreamZMQ          = 0
+	inputStreamNanomsg      = 1
+	inputStreamReplay       = 2 // replays recorded files
+	inputStreamReplaySource = 3 // source of messages in replayed files
+	inputStreamUpstream     = 4 // output stream of upstream tanglebeat, see federation
 )
 
 type inputRoutine struct {
@@ -27,24 +32,36 @@ type inputRoutine struct {
 	initialized            bool
 	inputStreamType        int
 	uri                    string
+	socket                 inSocket
 	txCount                uint64
 	ctxCount               uint64
 	lmiCount               int
 	lastLmi                int
+	lastLmiAdvanced        time.Time
 	obsoleteSnCount        uint64
+	duplicateCount         uint64
 	lastSeenOnceRate       uint64
 	lastSeenSomeMinSNCount uint64
+	avgLatencySec          float64
+	weight                 float64
+	autoWeightFactor       float64
+	upstreamPath           string
+	evidenceCount          uint64
+	loopCount              uint64
+	inLoop                 bool // upstream with own instance id in its path, see federation
 	tsLastTXSomeMin        *ebuffer.EventTsExpiringBuffer
 	tsLastSNSomeMin        *ebuffer.EventTsExpiringBuffer
 }
 
-func createInputRoutine(uri string, inputStreamType int) {
+func createInputRoutine(uri string, inputStreamType int) bool {
 	ret := &inputRoutine{
-		InputReaderBase: *inreaders.NewInputReaderBase(),
-		inputStreamType: inputStreamType,
-		uri:             uri,
+		InputReaderBase:  *inreaders.NewInputReaderBase(),
+		inputStreamType:  inputStreamType,
+		uri:              uri,
+		weight:           getStaticWeight(uri),
+		autoWeightFactor: 1,
 	}
-	inputRoutines.AddInputReader(uri, ret)
+	return inputRoutines.AddInputReader(uri, ret)
 }
 
 var (
@@ -52,14 +69,23 @@ var (
 	compoundOutPublisher *nanomsg.Publisher
 )
 
-func MustInitInputRoutines(outEnabled bool, outPort int, inputsZMQ []string, inputsNanomsg []string) {
+func MustInitInputRoutines(outEnabled bool, outPort int, outTLS bool, outZMQPort int, inputsZMQ []string, inputsNanomsg []string, inputsReplay []string) {
 	initZmqMetrics()
 	initMsgFilter()
 	initValueTx()
+	initWatchList()
+	initOutFilters()
 
 	inputRoutines = inreaders.NewInputReaderSet("inreader set")
+	inputRoutines.SetIdQuarantine(idQuarantine(cfg.Get().RetentionPeriodMin))
+	// before inputs are added: they take ids from the snapshot
+	loadCacheSnapshot()
 	var err error
-	compoundOutPublisher, err = nanomsg.NewPublisher(outEnabled, outPort, 0, localLog)
+	var tlsCfg *nanomsg.TLSServerYAML
+	if outTLS {
+		tlsCfg = &cfg.Config.TLS.Server
+	}
+	compoundOutPublisher, err = nanomsg.NewPublisherTLS(outEnabled, outPort, 0, tlsCfg, localLog)
 	if err != nil {
 		errorf("Failed to create publishing channel. Publisher is disabled: %v", err)
 		panic(err)
@@ -69,6 +95,10 @@ func MustInitInputRoutines(outEnabled bool, outPort int, inputsZMQ []string, inp
 	} else {
 		infof("Publisher for output stream is DISABLED")
 	}
+	if err = initZMQOutput(outZMQPort); err != nil {
+		errorf("Failed to create ZMQ output: %v", err)
+		panic(err)
+	}
 
 	for _, uri := range inputsZMQ {
 		createInputRoutine(uri, inputStreamZMQ)
@@ -76,8 +106,17 @@ func MustInitInputRoutines(outEnabled bool, outPort int, inputsZMQ []string, inp
 	for _, uri := range inputsNanomsg {
 		createInputRoutine(uri, inputStreamNanomsg)
 	}
+	for _, uri := range inputsReplay {
+		createInputRoutine(uri, inputStreamReplay)
+	}
+	initFederation()
+	startRecorder()
 	startOutValveRoutine()
+	startHealthRoutine()
 	startEchoLatencyRoutine()
+	startAdaptiveQuorumRoutine()
+	startWeightsRoutine()
+	startSnapshotRoutine()
 }
 
 func (r *inputRoutine) GetUri() string {
@@ -134,14 +173,66 @@ func (r *inputRoutine) uninit() {
 	r.txCount = 0
 	r.ctxCount = 0
 	r.obsoleteSnCount = 0
+	r.duplicateCount = 0
+	r.avgLatencySec = 0
+	r.evidenceCount = 0
+	r.loopCount = 0
 	r.tsLastTXSomeMin = nil
 	r.tsLastSNSomeMin = nil
 	r.initialized = false
 }
 
-// TODO dynamically / upon user action add, delete, disable, enable input streams
 // TODO nanomsg routines, inputRoutine code reuse
 
+// Stop closes the socket of the running routine. Run returns
+func (r *inputRoutine) Stop() {
+	r.Lock()
+	socket := r.socket
+	r.socket = nil
+	r.Unlock()
+	// closing may take time, not under lock
+	if socket != nil {
+		socket.Close()
+	}
+}
+
+// silenceWatchdog closes the socket if nothing comes from the input for 'inputSilenceTimeoutSec'.
+// Connection may die silently and RecvMsg would block forever.
+// Closed socket makes RecvMsg return error and routine is restarted
+func (r *inputRoutine) silenceWatchdog(uri string, chStop chan struct{}, chSilence chan error) {
+	started := time.Now()
+	ticker := time.NewTicker(silenceCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-chStop:
+			return
+		case <-ticker.C:
+		}
+		last := r.GetLastHeartbeat()
+		if last.Before(started) {
+			last = started
+		}
+		timeout := time.Duration(cfg.Get().InputSilenceTimeoutSec) * time.Second
+		if time.Since(last) > timeout {
+			chSilence <- fmt.Errorf("no messages from '%v' for %v", uri, timeout)
+			r.Stop()
+			return
+		}
+	}
+}
+
+func (r *inputRoutine) setSocket(socket inSocket) bool {
+	r.Lock()
+	defer r.Unlock()
+	if r.IsDisabled__() {
+		// was disabled while connecting
+		return false
+	}
+	r.socket = socket
+	return true
+}
+
 func (r *inputRoutine) Run(name string) inreaders.ReasonNotRunning {
 	r.init()
 	defer r.uninit()
@@ -155,6 +246,12 @@ func (r *inputRoutine) Run(name string) inreaders.ReasonNotRunning {
 		socket, err = NewZmqSocket(uri, topics)
 	case inputStreamNanomsg:
 		socket, err = NewNanomsgSocket(uri, topics)
+	case inputStreamReplay:
+		return r.runReplay()
+	case inputStreamReplaySource:
+		socket = newReplaySourceSocket(uri)
+	case inputStreamUpstream:
+		socket, err = NewNanomsgSocket(uri, upstreamTopics)
 	default:
 		panic("wrong input stream type")
 	}
@@ -164,24 +261,47 @@ func (r *inputRoutine) Run(name string) inreaders.ReasonNotRunning {
 		r.SetLastErr(fmt.Sprintf("%v", err))
 		return inreaders.REASON_NORUN_ERROR
 	}
-	defer socket.Close()
+	if !r.setSocket(socket) {
+		socket.Close()
+		return inreaders.REASON_NORUN_DISABLED
+	}
+	defer r.Stop()
 
 	r.SetReading(true)
 
+	// replay source is silent by design
+	chSilence := make(chan error, 1)
+	if r.inputStreamType != inputStreamReplaySource {
+		chStopWatchdog := make(chan struct{})
+		defer close(chStopWatchdog)
+		go r.silenceWatchdog(uri, chStopWatchdog, chSilence)
+	}
+
 	infof("Successfully started input routine for %v", uri)
 	for {
 		msg, msgSplit, err := socket.RecvMsg()
 
 		if err != nil {
+			if r.IsDisabled() {
+				infof("Stopped input routine for %v", uri)
+				return inreaders.REASON_NORUN_DISABLED
+			}
+			select {
+			case errSilence := <-chSilence:
+				err = errSilence
+			default:
+			}
 			errorf("%v", err)
 			r.SetLastErr(fmt.Sprintf("%v", err))
 			return inreaders.REASON_NORUN_ERROR
 		}
 		r.SetLastHeartbeatNow()
+		ts := utils.UnixMsNow()
+		recordMsg(uri, msg, ts)
 
 		// send to filter's channel
-		if expectedTopic(msgSplit[0]) {
-			toFilter(r, msg, msgSplit)
+		if expectedTopic(msgSplit[0]) || (msgSplit[0] == "seen" && r.inputStreamType == inputStreamUpstream) {
+			toFilter(r, msg, msgSplit, ts)
 		}
 	}
 }
@@ -213,9 +333,23 @@ func (r *inputRoutine) accountLmi(index int) {
 		return
 	}
 	r.lmiCount++
+	if index > r.lastLmi {
+		r.lastLmiAdvanced = time.Now()
+	}
 	r.lastLmi = index
 }
 
+// same message came from the same source more than once
+func (r *inputRoutine) accountDuplicate(msgType string) {
+	updateDuplicateMsgCounter(r.GetUri(), msgType)
+	r.Lock()
+	defer r.Unlock()
+	if !r.initialized {
+		return
+	}
+	r.duplicateCount++
+}
+
 func (r *inputRoutine) incObsoleteCount() {
 	r.Lock()
 	defer r.Unlock()
@@ -237,17 +371,26 @@ type ZmqRoutineStats struct {
 	CtxCountSomeMin      uint64 `json:"ctxCountSomeMin"`
 	timeIntervalSec10min uint64
 	ObsoleteConfirmCount uint64  `json:"obsoleteSNCount"`
+	DuplicateCount       uint64  `json:"duplicateCount"`
 	Tps                  float64 `json:"tps"`
 	Ctps                 float64 `json:"ctps"`
 	Confrate             uint64  `json:"confrate"`
 	LmiCount             int     `json:"lmiCount"`
 	LastLmi              int     `json:"lastLmi"`
+	LmiLag               int     `json:"lmiLag"`
+	LmiStaleSec          uint64  `json:"lmiStaleSec"`
 	SeenOnceRate         uint64  `json:"seenOnceRate"`
+	AvgLatencySec        float64 `json:"avgLatencySec"`
+	Weight               float64 `json:"weight"`
+	UpstreamPath         string  `json:"upstreamPath,omitempty"`
+	EvidenceCount        uint64  `json:"evidenceCount,omitempty"`
+	LoopCount            uint64  `json:"loopCount,omitempty"`
 	State                string  `json:"state"`
 	routine              *inputRoutine
 }
 
 func (r *inputRoutine) getStats() *ZmqRoutineStats {
+	lmiPassed := getLmiPassed()
 	// lock for writing due to seenOnceRate update
 	r.Lock()
 	defer r.Unlock()
@@ -276,10 +419,16 @@ func (r *inputRoutine) getStats() *ZmqRoutineStats {
 
 	r.lastSeenOnceRate = uint64(getSeenOnceRate5to1Min(r.GetId__()))
 	r.lastSeenSomeMinSNCount = uint64(numLastSN5Min)
+	r.autoWeightFactor = calcAutoWeightFactor(r.lastSeenOnceRate, r.obsoleteSnCount, r.ctxCount, r.avgLatencySec)
 
 	typ := "zmq"
-	if r.inputStreamType == inputStreamNanomsg {
+	switch r.inputStreamType {
+	case inputStreamNanomsg:
 		typ = "nanomsg"
+	case inputStreamReplay, inputStreamReplaySource:
+		typ = "replay"
+	case inputStreamUpstream:
+		typ = "upstream"
 	}
 	ret := &ZmqRoutineStats{
 		Uri:                  r.uri,
@@ -294,20 +443,30 @@ func (r *inputRoutine) getStats() *ZmqRoutineStats {
 		CtxCountSomeMin:      uint64(numLastSN5Min),
 		timeIntervalSec10min: timeIntervalSec,
 		ObsoleteConfirmCount: r.obsoleteSnCount,
+		DuplicateCount:       r.duplicateCount,
 		Ctps:                 ctps,
 		Confrate:             confrate,
 		LmiCount:             r.lmiCount,
 		LastLmi:              r.lastLmi,
 		SeenOnceRate:         r.lastSeenOnceRate,
+		AvgLatencySec:        math.Round(100*r.avgLatencySec) / 100,
+		Weight:               r.weight,
+		UpstreamPath:         r.upstreamPath,
+		EvidenceCount:        r.evidenceCount,
+		LoopCount:            r.loopCount,
 	}
+	ret.LmiLag, ret.LmiStaleSec = r.getLmiLag__(lmiPassed)
 	if ret.Running {
 		lastHBSec := utils.SinceUnixMs(ret.LastHeartbeatTs) / 1000
 		switch {
 		case lastHBSec < 60:
-			if sncache.firstMilestoneArrived() {
-				ret.State = "running"
-			} else {
+			switch {
+			case !sncache.firstMilestoneArrived():
 				ret.State = "wait_milestone"
+			case isLagging(ret.LmiLag, ret.LmiStaleSec):
+				ret.State = "lagging"
+			default:
+				ret.State = "running"
 			}
 		case lastHBSec < 300:
 			ret.State = "slow"
@@ -315,12 +474,38 @@ func (r *inputRoutine) getStats() *ZmqRoutineStats {
 			ret.State = "inactive"
 		}
 	} else {
-		ret.State = string(r.GetOnHoldInfo__())
+		if r.IsDisabled__() {
+			ret.State = string(inreaders.REASON_NORUN_DISABLED)
+		} else {
+			ret.State = string(r.GetOnHoldInfo__())
+		}
 	}
 	ret.routine = r
 	return ret
 }
 
+// lag behind the quorum milestone and seconds since milestone index of the input last advanced
+func (r *inputRoutine) getLmiLag__(lmiPassed int) (int, uint64) {
+	if r.lastLmi == 0 {
+		return 0, 0
+	}
+	var lag int
+	if lmiPassed > r.lastLmi {
+		lag = lmiPassed - r.lastLmi
+	}
+	return lag, uint64(time.Since(r.lastLmiAdvanced).Seconds())
+}
+
+// input is lagging if it is more than 'maxLagMilestones' behind the quorum milestone or if it is behind
+// and its milestone didn't advance for 'maxStaleMin' minutes
+func isLagging(lag int, staleSec uint64) bool {
+	if lag == 0 {
+		return false
+	}
+	c := cfg.Get().SyncLag
+	return lag > c.MaxLagMilestones || staleSec > uint64(c.MaxStaleMin)*60
+}
+
 func GetInputStats() []*ZmqRoutineStats {
 	ret := make([]*ZmqRoutineStats, 0, 10)
 	inputRoutines.ForEach(func(name string, ir inreaders.InputReader) {
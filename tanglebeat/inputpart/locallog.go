@@ -25,7 +25,7 @@ func errorf(format string, args ...interface{}) {
 }
 
 func debugf(format string, args ...interface{}) {
-	if !cfg.Config.Debug {
+	if !cfg.Get().Debug {
 		return
 	}
 	if localLog != nil {
@@ -1,6 +1,9 @@
 package inputpart
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"github.com/op/go-logging"
 	. "github.com/prometheus/client_golang/prometheus"
@@ -34,6 +37,10 @@ var (
 	lmConfRate30minMetrics Gauge
 
 	multiQuorumTps *CounterVec
+
+	duplicateMsgCounter *CounterVec
+
+	watchCounter *CounterVec
 )
 
 func initZmqMetrics() {
@@ -89,6 +96,18 @@ func initZmqMetrics() {
 	})
 	MustRegister(zmqMetricsCtxCounterCompound)
 
+	duplicateMsgCounter = NewCounterVec(CounterOpts{
+		Name: "tanglebeat_duplicate_msg_counter",
+		Help: "Number of messages received more than once from the same input, labeled by input uri and message type",
+	}, []string{"uri", "type"})
+	MustRegister(duplicateMsgCounter)
+
+	watchCounter = NewCounterVec(CounterOpts{
+		Name: "tanglebeat_watch_counter",
+		Help: "Number of appearances (tx) and confirmations (sn) of watched addresses, labeled by watch name",
+	}, []string{"watch", "type"})
+	MustRegister(watchCounter)
+
 	metricsMiotaPriceUSD = NewGauge(GaugeOpts{
 		Name: "tanglebeat_miota_price_usd",
 		Help: "Price USD/MIOTA, labeled by source",
@@ -140,7 +159,7 @@ func initZmqMetrics() {
 	})
 	MustRegister(lmConfRate30minMetrics)
 
-	if cfg.Config.MultiQuorumMetricsEnabled {
+	if cfg.Get().MultiQuorumMetricsEnabled {
 		multiQuorumTps = NewCounterVec(CounterOpts{
 			Name: "tanglebeat_multiquorum_tps",
 			Help: "TPS labeled by quorums",
@@ -167,11 +186,55 @@ func updateCompoundMetrics(msgtype string) {
 }
 
 func updateMultiQuorumTpsCounter(quorum int) {
-	if cfg.Config.MultiQuorumMetricsEnabled {
+	if cfg.Get().MultiQuorumMetricsEnabled {
 		multiQuorumTps.With(Labels{"quorum": fmt.Sprintf("%d", quorum)}).Inc()
 	}
 }
 
+// secret of the instance: masked uri can't be found by hashing candidate addresses
+var metricsUriSalt = newMetricsUriSalt()
+
+func newMetricsUriSalt() []byte {
+	ret := make([]byte, 32)
+	if _, err := rand.Read(ret); err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// MetricsUriLabel returns uri of the input as a label of metrics.
+// If 'inputMetricsMaskIP' is set, uri with IP address is replaced by 'IP addr (masked) #<hash>', same as
+// in the masked stats, with the salted hash to keep labels of inputs distinct.
+// Hash doesn't depend on the order inputs were added but changes after restart
+func MetricsUriLabel(uri string) string {
+	if cfg.Get().InputMetricsMaskIP && utils.IsIpAddr(uri) {
+		h := hmac.New(sha256.New, metricsUriSalt)
+		_, _ = h.Write([]byte(uri))
+		return fmt.Sprintf("IP addr (masked) #%x", h.Sum(nil)[:4])
+	}
+	return uri
+}
+
+func updateDuplicateMsgCounter(uri string, msgType string) {
+	duplicateMsgCounter.With(Labels{"uri": MetricsUriLabel(uri), "type": msgType}).Inc()
+}
+
+func deleteDuplicateMsgCounter(uri string) {
+	for _, t := range []string{"tx", "sn"} {
+		duplicateMsgCounter.Delete(Labels{"uri": MetricsUriLabel(uri), "type": t})
+	}
+}
+
+func updateWatchCounter(name, msgType string) {
+	watchCounter.With(Labels{"watch": name, "type": msgType}).Inc()
+}
+
+func deleteWatchCounter(name string) {
+	for _, t := range []string{"tx", "sn"} {
+		watchCounter.Delete(Labels{"watch": name, "type": t})
+	}
+}
+
 func updateEchoMetrics(percNotSeen, avgSeenFirstMs, avgSeenLastMs uint64) {
 	echoNotSeenPerc.Set(float64(percNotSeen))
 	echoMetricsAvgFirstSeen.Set(float64(avgSeenFirstMs))
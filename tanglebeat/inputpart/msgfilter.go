@@ -2,11 +2,11 @@ package inputpart
 
 import (
 	"fmt"
-	"github.com/unioproject/tanglebeat/lib/utils"
 	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
 	"github.com/unioproject/tanglebeat/tanglebeat/hashcache"
 	"math"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -20,9 +20,10 @@ var (
 	txcache          *hashcache.HashCacheBase
 	sncache          *hashCacheSN
 	lastLMI          int
-	lastLMITimesSeen int
+	lastLMISources   hashcache.SourceSet // inputs which have seen the last lmi
 	lastLMIFirstSeen uint64
 	lastLMILastSeen  uint64
+	lastLMIPassed    int // last milestone index which passed the quorum
 	lmiMutex         = &sync.RWMutex{}
 	lmhsCache        *hashcache.HashCacheBase
 )
@@ -31,22 +32,24 @@ type zmqMsg struct {
 	routine  *inputRoutine
 	msgData  []byte   // original data
 	msgSplit []string // same split to strings
+	ts       uint64   // arrival time, unix ms. Recorded time for replayed messages
 }
 
 const filterChanBufSize = 100
 
 var toFilterChan = make(chan *zmqMsg, filterChanBufSize)
 
-func toFilter(routine *inputRoutine, msgData []byte, msgSplit []string) {
+func toFilter(routine *inputRoutine, msgData []byte, msgSplit []string, ts uint64) {
 	toFilterChan <- &zmqMsg{
 		routine:  routine,
 		msgData:  msgData,
 		msgSplit: msgSplit,
+		ts:       ts,
 	}
 }
 
 func initMsgFilter() {
-	retentionPeriodSec := cfg.Config.RetentionPeriodMin * 60
+	retentionPeriodSec := cfg.Get().RetentionPeriodMin * 60
 
 	txcache = hashcache.NewHashCacheBase(
 		"txcache", useFirstHashTrytes, segmentDurationTXSec, retentionPeriodSec)
@@ -63,31 +66,39 @@ func initMsgFilter() {
 
 func msgFilterLoop() {
 	for msg := range toFilterChan {
-		filterMsg(msg.routine, msg.msgData, msg.msgSplit)
+		filterMsg(msg.routine, msg.msgData, msg.msgSplit, msg.ts)
 	}
 }
 
 // only start processing tx and sn messages after first two lmi messages arrived
 // the reason is to avoid (filter out) obsolete sn rubbish
-func filterMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
+func filterMsg(routine *inputRoutine, msgData []byte, msgSplit []string, ts uint64) {
+	if msgSplit[0] != "seen" && routine.inputStreamType == inputStreamUpstream && routine.isInFederationLoop() {
+		return
+	}
 	switch msgSplit[0] {
 	case "tx":
 		if sncache.firstMilestoneArrived() {
-			filterTXMsg(routine, msgData, msgSplit)
+			filterTXMsg(routine, msgData, msgSplit, ts)
 		}
 	case "sn":
 		if sncache.firstMilestoneArrived() {
-			filterSNMsg(routine, msgData, msgSplit)
+			filterSNMsg(routine, msgData, msgSplit, ts)
 		}
 	case "lmi":
-		filterLMIMsg(routine, msgData, msgSplit)
+		filterLMIMsg(routine, msgData, msgSplit, ts)
 
 	case "lmhs":
-		filterLMHSMsg(routine, msgData, msgSplit)
+		filterLMHSMsg(routine, msgData, msgSplit, ts)
+
+	case "seen":
+		if sncache.firstMilestoneArrived() {
+			filterSeenMsg(routine, msgData, msgSplit, ts)
+		}
 	}
 }
 
-func filterTXMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
+func filterTXMsg(routine *inputRoutine, msgData []byte, msgSplit []string, ts uint64) {
 	var entry hashcache.CacheEntry
 
 	if len(msgSplit) < 2 {
@@ -100,23 +111,60 @@ func filterTXMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
 		return // not putting into the cache
 	}
 
-	txcache.SeenHashBy(msgSplit[1], routine.GetId__(), nil, &entry)
+	weight := routine.getWeight()
+	seen, duplicate := txcache.SeenHashByWeightedAt(msgSplit[1], routine.GetId__(), weight, fedTxData(msgData), &entry, ts)
+	// quorum could be reached by evidence of upstreams before
+	releasePendingTx(msgData, msgSplit)
+	if duplicate {
+		// tx of the upstream coming after its evidence is expected, not a duplicate
+		if _, ok := entry.Evidence[routine.GetId__()]; !ok {
+			routine.accountDuplicate("tx")
+		}
+		return
+	}
+	if seen {
+		// latency relative to the first source. First source itself is not accounted
+		routine.accountLatency(entry.LastSeen - entry.FirstSeen)
+	}
 
 	// check and account for echo to the promotion transactions
-	checkForEcho(msgSplit[1], utils.UnixMsNow())
+	checkForEcho(msgSplit[1], ts)
 
-	// check if message was seen exactly number of times as configured (usually 2)
-	if int(entry.Visits) == GetTxQuorum() {
-		toOutput(msgData, msgSplit)
+	checkTxQuorum(msgSplit[1], msgData, msgSplit, &entry, 1, weight)
+}
+
+// releases tx message if the last visit(s) crossed the quorum. Evidence of the upstream may add several visits.
+// msgData is nil when called upon evidence: message is taken from the cache or released when it arrives
+func checkTxQuorum(hash string, msgData []byte, msgSplit []string, entry *hashcache.CacheEntry, added byte, weight float64) {
+	var crossed bool
+	if cfg.Get().WeightedQuorum {
+		// check if sum of weights of sources reached the quorum
+		crossed = weightCrossed(entry.Weight, float64(added)*weight, GetTxQuorum())
+	} else {
+		// check if number of visits reached the quorum (usually 2)
+		crossed = int(entry.Visits) >= GetTxQuorum() && int(entry.Visits-added) < GetTxQuorum()
+	}
+	if crossed {
+		if msgData == nil {
+			msgData, _ = entry.Data.([]byte)
+			if msgData != nil {
+				msgSplit = strings.Split(string(msgData), " ")
+			}
+		}
+		if msgData != nil {
+			toOutput(msgData, msgSplit)
+		} else {
+			addPendingTx(hash)
+		}
 	}
 	// update multiquorum tps metrics for quorums 1, 2, 3, 4, 5
-	if 1 <= int(entry.Visits) && int(entry.Visits) <= 5 {
-		updateMultiQuorumTpsCounter(int(entry.Visits))
+	for v := int(entry.Visits-added) + 1; v <= int(entry.Visits) && v <= 5; v++ {
+		updateMultiQuorumTpsCounter(v)
 	}
-	publishQuorumUpdate(msgSplit[1], int(entry.Visits))
+	publishQuorumUpdate(hash, int(entry.Visits))
 }
 
-func filterSNMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
+func filterSNMsg(routine *inputRoutine, msgData []byte, msgSplit []string, ts uint64) {
 	var hash string
 	var err error
 	var entry hashcache.CacheEntry
@@ -125,7 +173,7 @@ func filterSNMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
 		errorf("%v: Message %v is invalid", routine.GetUri(), string(msgData))
 		return
 	}
-	obsolete, err := checkObsoleteMsg(msgData, msgSplit, routine.GetUri())
+	obsolete, err := checkObsoleteMsg(msgData, msgSplit, routine.GetUri(), ts)
 	if err != nil {
 		errorf("checkObsoleteMsg: %v", err)
 		return
@@ -144,15 +192,25 @@ func filterSNMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
 	}
 	hash = msgSplit[2]
 
-	sncache.SeenHashBy(hash, routine.GetId__(), nil, &entry)
+	weight := routine.getWeight()
+	if _, duplicate := sncache.SeenHashByWeightedAt(hash, routine.GetId__(), weight, nil, &entry, ts); duplicate {
+		routine.accountDuplicate("sn")
+		return
+	}
 
-	// check if message was seen exactly number of times as configured (usually 2)
-	if int(entry.Visits) == GetSnQuorum() {
-		toOutput(msgData, msgSplit)
+	if cfg.Get().WeightedQuorum {
+		if weightCrossed(entry.Weight, weight, GetSnQuorum()) {
+			toOutput(msgData, msgSplit)
+		}
+	} else {
+		// check if message was seen exactly number of times as configured (usually 2)
+		if int(entry.Visits) == GetSnQuorum() {
+			toOutput(msgData, msgSplit)
+		}
 	}
 }
 
-func checkObsoleteMsg(msgData []byte, msgSplit []string, uri string) (bool, error) {
+func checkObsoleteMsg(msgData []byte, msgSplit []string, uri string, ts uint64) (bool, error) {
 	if len(msgSplit) < 3 {
 		return false, fmt.Errorf("%v: Message %v is invalid", uri, string(msgData))
 	}
@@ -160,11 +218,11 @@ func checkObsoleteMsg(msgData []byte, msgSplit []string, uri string) (bool, erro
 	if err != nil {
 		return false, fmt.Errorf("expected index, found %v", msgSplit[1])
 	}
-	obsolete, _ := sncache.checkCurrentMilestoneIndex(index, uri)
+	obsolete, _ := sncache.checkCurrentMilestoneIndex(index, uri, ts)
 	return obsolete, nil
 }
 
-func filterLMIMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
+func filterLMIMsg(routine *inputRoutine, msgData []byte, msgSplit []string, ts uint64) {
 	index, err := strconv.Atoi(msgSplit[1])
 	if err != nil {
 		errorf("Invalid 'lmi' message: at index 1 expected to be milestone index: %v", err)
@@ -173,7 +231,7 @@ func filterLMIMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
 	if !sncache.firstMilestoneArrived() {
 		uri := routine.GetUri()
 		infof("+++++++++++++++++ Milestone %v arrived from %v", index, uri)
-		sncache.checkCurrentMilestoneIndex(index, uri)
+		sncache.checkCurrentMilestoneIndex(index, uri, ts)
 	}
 	routine.accountLmi(index)
 	if routine.IsOutputClosed() {
@@ -186,21 +244,26 @@ func filterLMIMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
 	switch {
 	case index > lastLMI:
 		lastLMI = index
-		lastLMITimesSeen = 0
-		lastLMIFirstSeen = utils.UnixMsNow()
-		lastLMILastSeen = utils.UnixMsNow()
-	case index == lastLMI:
-		lastLMITimesSeen++
-		lastLMILastSeen = utils.UnixMsNow()
-		if lastLMITimesSeen == GetLmiQuorum() {
-			toOutput(msgData, msgSplit)
-		}
+		lastLMISources = hashcache.SourceSet{}
+		lastLMIFirstSeen = ts
+	case index < lastLMI:
+		return
+	}
+	// same input repeating the lmi doesn't count
+	if lastLMISources.Contains(routine.GetId__()) {
+		return
+	}
+	lastLMISources.Add(routine.GetId__())
+	lastLMILastSeen = ts
+	if lastLMIPassed < index && lastLMISources.Count() >= GetLmiQuorum() {
+		lastLMIPassed = index
+		toOutput(msgData, msgSplit)
 	}
 }
 
 // TODO how to find out which lmhs message corresponds to the latest milestone
 
-func filterLMHSMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
+func filterLMHSMsg(routine *inputRoutine, msgData []byte, msgSplit []string, ts uint64) {
 	if len(msgSplit) < 2 {
 		errorf("strange message %v", string(msgData))
 		return
@@ -210,14 +273,15 @@ func filterLMHSMsg(routine *inputRoutine, msgData []byte, msgSplit []string) {
 	}
 	var entry hashcache.CacheEntry
 
-	lmhsCache.SeenHashBy(msgSplit[1], routine.GetId__(), nil, &entry)
+	lmhsCache.SeenHashByWeightedAt(msgSplit[1], routine.GetId__(), 1, nil, &entry, ts)
 	//infof("+++++ New lmhs '%v' #%v", string(msgData), entry.Visits)
 
 	// if msg is seen QuorumMilestoneHashToPass times during TimeIntervalMilestoneHashToPassMsec
 	// it is passed
-	if int(entry.Visits) == cfg.Config.QuorumMilestoneHashToPass {
+	c := cfg.Get()
+	if int(entry.Visits) == c.QuorumMilestoneHashToPass {
 		interv := entry.LastSeen - entry.FirstSeen
-		if interv < cfg.Config.TimeIntervalMilestoneHashToPassMsec {
+		if interv < c.TimeIntervalMilestoneHashToPassMsec {
 			toOutput(msgData, msgSplit)
 			infof("New milestone hash '%v' pass: seen %v times within interval of %v msec", string(msgData), entry.Visits, interv)
 		}
@@ -231,3 +295,9 @@ func getLmiStats() (int, float64) {
 	latencySec = math.Round(latencySec*100) / 100
 	return lastLMI, latencySec
 }
+
+func getLmiPassed() int {
+	lmiMutex.RLock()
+	defer lmiMutex.RUnlock()
+	return lastLMIPassed
+}
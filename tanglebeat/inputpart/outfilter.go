@@ -0,0 +1,186 @@
+package inputpart
+
+import (
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// filtered derived topics of the output stream.
+// Filter '<ftx|fsn>.<tag|addr|bundle>.<value>' is a topic name. Each quorum-passed 'tx' or 'sn' message which matches
+// the filter is published once more, with the filter as a topic: '<filter> <the rest of the original message>'.
+// So clients can subscribe only to messages they need, for example to 'ftx.tag.TANGLE9BEAT'.
+// Prefixes 'ftx' and 'fsn' are distinct, so subscribers of 'tx' and 'sn' don't receive derived messages
+// (Nanomsg and ZMQ subscriptions match by prefix).
+// Tag matches if it starts with the value, address and bundle must be equal.
+// Filters come from the config file and can be added/removed at runtime with admin API.
+// Filters added at runtime are not saved
+
+type outFilter struct {
+	topic      string
+	msgType    string
+	fieldIdx   int
+	isPrefix   bool
+	value      string
+	fromConfig bool
+}
+
+type OutFilterInfo struct {
+	Topic      string `json:"topic"`
+	FromConfig bool   `json:"fromConfig"`
+}
+
+// index of the field in the message by message type and field name
+var outFilterFields = map[string]map[string]int{
+	"tx": {"tag": 12, "addr": 2, "bundle": 8},
+	"sn": {"addr": 3, "bundle": 6},
+}
+
+// prefix of the derived topic by message type
+var outFilterPrefixes = map[string]string{
+	"ftx": "tx",
+	"fsn": "sn",
+}
+
+// returns type of the original message if topic is a derived one
+func derivedMsgType(topic string) (string, bool) {
+	ret, ok := outFilterPrefixes[strings.SplitN(topic, ".", 2)[0]]
+	return ret, ok
+}
+
+var (
+	outFilters      = make(map[string]*outFilter)
+	outFiltersMutex sync.RWMutex
+)
+
+func parseOutFilter(topic string) (*outFilter, error) {
+	parts := strings.SplitN(topic, ".", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return nil, fmt.Errorf("wrong filter '%v': expected '<ftx|fsn>.<tag|addr|bundle>.<value>'", topic)
+	}
+	msgType, ok := outFilterPrefixes[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("wrong filter '%v': must start with 'ftx' or 'fsn'", topic)
+	}
+	idx, ok := outFilterFields[msgType][parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("wrong filter '%v': '%v' can't be filtered by '%v'", topic, msgType, parts[1])
+	}
+	value := parts[2]
+	var err error
+	switch parts[1] {
+	case "addr":
+		value, err = normalizeAddress(value)
+	case "bundle":
+		value, err = normalizeHash(value, "bundle hash")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("wrong filter '%v': %v", topic, err)
+	}
+	if strings.ContainsAny(value, " \t") {
+		return nil, fmt.Errorf("wrong filter '%v': contains spaces", topic)
+	}
+	return &outFilter{
+		topic:    strings.Join([]string{parts[0], parts[1], value}, "."),
+		msgType:  msgType,
+		fieldIdx: idx,
+		isPrefix: parts[1] == "tag",
+		value:    value,
+	}, nil
+}
+
+func initOutFilters() {
+	SetConfigOutFilters(cfg.Get().OutputFilters)
+}
+
+// SetConfigOutFilters replaces filters from the config file. Filters added at runtime remain
+func SetConfigOutFilters(topics []string) {
+	outFiltersMutex.Lock()
+	defer outFiltersMutex.Unlock()
+
+	for topic, f := range outFilters {
+		if f.fromConfig {
+			delete(outFilters, topic)
+		}
+	}
+	for _, topic := range topics {
+		f, err := parseOutFilter(topic)
+		if err != nil {
+			errorf("Output filters: %v", err)
+			continue
+		}
+		f.fromConfig = true
+		outFilters[f.topic] = f
+	}
+	if len(outFilters) > 0 {
+		infof("Output filters: %d derived topics", len(outFilters))
+	}
+}
+
+func AddOutFilter(topic string) error {
+	f, err := parseOutFilter(topic)
+	if err != nil {
+		return err
+	}
+	outFiltersMutex.Lock()
+	defer outFiltersMutex.Unlock()
+
+	if _, ok := outFilters[f.topic]; ok {
+		return fmt.Errorf("filter '%v' already exists", f.topic)
+	}
+	outFilters[f.topic] = f
+	infof("Output filters: added '%v'", f.topic)
+	return nil
+}
+
+func RemoveOutFilter(topic string) error {
+	f, err := parseOutFilter(topic)
+	if err != nil {
+		return err
+	}
+	outFiltersMutex.Lock()
+	defer outFiltersMutex.Unlock()
+
+	if _, ok := outFilters[f.topic]; !ok {
+		return fmt.Errorf("filter '%v' doesn't exist", f.topic)
+	}
+	delete(outFilters, f.topic)
+	infof("Output filters: removed '%v'", f.topic)
+	return nil
+}
+
+func GetOutFilters() []*OutFilterInfo {
+	outFiltersMutex.RLock()
+	defer outFiltersMutex.RUnlock()
+
+	ret := make([]*OutFilterInfo, 0, len(outFilters))
+	for _, f := range outFilters {
+		ret = append(ret, &OutFilterInfo{Topic: f.topic, FromConfig: f.fromConfig})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Topic < ret[j].Topic })
+	return ret
+}
+
+func (f *outFilter) match(msgSplit []string) bool {
+	if msgSplit[0] != f.msgType || f.fieldIdx >= len(msgSplit) {
+		return false
+	}
+	if f.isPrefix {
+		return strings.HasPrefix(msgSplit[f.fieldIdx], f.value)
+	}
+	return msgSplit[f.fieldIdx] == f.value
+}
+
+// called for each quorum-passed message
+func processOutFilters(msgSplit []string) {
+	outFiltersMutex.RLock()
+	defer outFiltersMutex.RUnlock()
+
+	for _, f := range outFilters {
+		if f.match(msgSplit) {
+			publishDerived(append([]string{f.topic}, msgSplit[1:]...)...)
+		}
+	}
+}
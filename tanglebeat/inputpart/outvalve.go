@@ -1,42 +1,317 @@
 package inputpart
 
-import "time"
+import (
+	"fmt"
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// output valve of the input is closed by valve policies. Policies are configured as an ordered list 'outputValve'.
+// Every 10 sec policies are checked for each input in the order of the list. Valve is closed by the first policy
+// which fires and is open if none of them does.
+// Some stats (latency, seen once rate) are not updated while the valve is closed. So valve closed by a policy
+// with 'holdMin' > 0 is reopened after 'holdMin' minutes and that policy is not applied to the input
+// for 'valveProbationPeriod', to collect fresh stats.
+// Policies based on stats (TPS, latency, seen once rate) are not applied during 'valveStartDelay' after start,
+// until stats are collected. 'outOfSync' is applied from the start
+
+const (
+	valveCheckPeriod     = 10 * time.Second
+	valveStartDelay      = 3 * time.Minute
+	valveProbationPeriod = 2 * time.Minute
+	valveEventsToKeep    = 100
+)
+
+// data common to all inputs, calculated once per check
+type valveContext struct {
+	avgTps    float64 // average TPS of inputs which confirm something
+	warmingUp bool    // stats are not collected yet
+}
+
+type valvePolicy interface {
+	name() string
+	// returns reason to close the valve of the input or empty string
+	check(st *ZmqRoutineStats, ctx *valveContext) string
+	// minimum time valve stays closed. 0 means valve is open as soon as policy doesn't fire
+	holdTime() time.Duration
+	// policy needs stats collected for some time, it is not applied during 'valveStartDelay'
+	needsStats() bool
+}
+
+type ValveEvent struct {
+	Ts     uint64 `json:"ts"`
+	Uri    string `json:"uri"`
+	Closed bool   `json:"closed"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// per input state of the valve loop
+type valveState struct {
+	policy     valvePolicy // policy which closed the valve. nil if open
+	closedAt   time.Time
+	lastPolicy string // policy which closed the valve last time
+	reopenedAt time.Time
+}
+
+var (
+	valveEvents      = make([]*ValveEvent, 0, valveEventsToKeep)
+	valveEventsMutex sync.RWMutex
+)
 
 func startOutValveRoutine() {
 	go outputValveLoop()
 	infof("Started 'outputValveLoop'")
 }
 
-func outputValveLoop() {
-	time.Sleep(3 * time.Minute)
-	for {
-		time.Sleep(2 * time.Minute)
+func newValvePolicy(pc cfg.ValvePolicyYAML) (valvePolicy, error) {
+	hold := time.Duration(pc.HoldMin) * time.Minute
+	switch pc.Name {
+	case "spam":
+		if pc.Threshold == 0 {
+			pc.Threshold = 2
+		}
+		return &spamPolicy{tpsFactor: pc.Threshold, hold: hold}, nil
+	case "outOfSync":
+		return &outOfSyncPolicy{hold: hold}, nil
+	case "seenOnce":
+		if pc.Threshold == 0 {
+			pc.Threshold = 50
+		}
+		if hold == 0 {
+			hold = 10 * time.Minute
+		}
+		return &seenOncePolicy{maxRate: pc.Threshold, hold: hold}, nil
+	case "latency":
+		if pc.Threshold == 0 {
+			pc.Threshold = 10
+		}
+		if hold == 0 {
+			hold = 10 * time.Minute
+		}
+		return &latencyPolicy{maxLatencySec: pc.Threshold, hold: hold}, nil
+	}
+	return nil, fmt.Errorf("unknown output valve policy '%v'", pc.Name)
+}
 
-		stats := GetInputStats()
-		if len(stats) == 0 {
+func newValvePolicies(list []cfg.ValvePolicyYAML) []valvePolicy {
+	ret := make([]valvePolicy, 0, len(list))
+	for _, pc := range list {
+		p, err := newValvePolicy(pc)
+		if err != nil {
+			errorf("Output valve: %v", err)
 			continue
 		}
-		var avgTps, num float64
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+func outputValveLoop() {
+	started := time.Now()
+	var policyCfg []cfg.ValvePolicyYAML
+	var policies []valvePolicy
+	states := make(map[*inputRoutine]*valveState)
+	for ; ; time.Sleep(valveCheckPeriod) {
+		// config may be reloaded
+		if c := cfg.Get().OutputValve; !reflect.DeepEqual(policyCfg, c) {
+			policyCfg = c
+			policies = newValvePolicies(policyCfg)
+		}
+		stats := GetInputStats()
+		ctx := newValveContext(stats)
+		ctx.warmingUp = time.Since(started) < valveStartDelay
+		newStates := make(map[*inputRoutine]*valveState, len(stats))
 		for _, st := range stats {
-			if st.Ctps > 0 {
-				avgTps += st.Tps
-				num++
+			vs, ok := states[st.routine]
+			if !ok {
+				vs = &valveState{}
 			}
+			newStates[st.routine] = vs
+			checkValve(st, vs, policies, ctx)
+		}
+		states = newStates
+	}
+}
+
+func newValveContext(stats []*ZmqRoutineStats) *valveContext {
+	var sumTps, num float64
+	for _, st := range stats {
+		if st.Ctps > 0 {
+			sumTps += st.Tps
+			num++
 		}
-		if num == 0 {
+	}
+	ret := &valveContext{}
+	if num != 0 {
+		ret.avgTps = sumTps / num
+	}
+	return ret
+}
+
+func checkValve(st *ZmqRoutineStats, vs *valveState, policies []valvePolicy, ctx *valveContext) {
+	if vs.policy != nil && vs.policy.holdTime() > 0 {
+		if time.Since(vs.closedAt) < vs.policy.holdTime() {
+			return // hold the valve closed
+		}
+		// stats of the policy are stale, it must be re-evaluated on fresh data
+		vs.reopenedAt = time.Now()
+	}
+	inProbation := time.Since(vs.reopenedAt) < valveProbationPeriod
+	var policy valvePolicy
+	var reason string
+	for _, p := range policies {
+		if inProbation && p.name() == vs.lastPolicy {
 			continue
 		}
-		avgTps = avgTps / num
-		var numOpen, numClosed int
-		for _, st := range stats {
-			closeValve := st.Ctps == 0 && st.Tps > 2*avgTps
-			st.routine.SetOutputClosed(closeValve)
-			if closeValve {
-				numClosed++
-			} else {
-				numOpen++
-			}
+		if ctx.warmingUp && p.needsStats() {
+			continue
 		}
-		infof("Output valve: open %v, closed %v", numOpen, numClosed)
+		if reason = p.check(st, ctx); reason != "" {
+			policy = p
+			break
+		}
+	}
+	// reason changes with stats, event is recorded only when valve is opened/closed or policy changes
+	changed := (policy == nil) != (vs.policy == nil) || (policy != nil && policy.name() != vs.policy.name())
+	if changed && policy != nil {
+		vs.closedAt = time.Now()
+	}
+	vs.policy = policy
+	if policy != nil {
+		vs.lastPolicy = policy.name()
+	}
+	st.routine.SetOutputClosed(policy != nil, reason)
+	if changed {
+		recordValveEvent(st.Uri, policy != nil, reason)
+	}
+}
+
+func recordValveEvent(uri string, closed bool, reason string) {
+	if closed {
+		infof("Output valve of '%v' CLOSED: %v", uri, reason)
+	} else {
+		infof("Output valve of '%v' OPEN", uri)
+	}
+	valveEventsMutex.Lock()
+	defer valveEventsMutex.Unlock()
+	valveEvents = append(valveEvents, &ValveEvent{
+		Ts:     utils.UnixMsNow(),
+		Uri:    uri,
+		Closed: closed,
+		Reason: reason,
+	})
+	if len(valveEvents) > valveEventsToKeep {
+		valveEvents = valveEvents[len(valveEvents)-valveEventsToKeep:]
 	}
 }
+
+// GetValveEvents returns last changes of output valves, latest last
+func GetValveEvents() []*ValveEvent {
+	valveEventsMutex.RLock()
+	defer valveEventsMutex.RUnlock()
+	ret := make([]*ValveEvent, len(valveEvents))
+	copy(ret, valveEvents)
+	return ret
+}
+
+// input which doesn't confirm anything while having TPS much higher than average is considered a spammer
+type spamPolicy struct {
+	tpsFactor float64
+	hold      time.Duration
+}
+
+func (p *spamPolicy) name() string {
+	return "spam"
+}
+
+func (p *spamPolicy) check(st *ZmqRoutineStats, ctx *valveContext) string {
+	if ctx.avgTps == 0 || st.Ctps != 0 || st.Tps <= p.tpsFactor*ctx.avgTps {
+		return ""
+	}
+	return fmt.Sprintf("spam: no confirmations while TPS %v > %v x average TPS %.2f", st.Tps, p.tpsFactor, ctx.avgTps)
+}
+
+func (p *spamPolicy) holdTime() time.Duration {
+	return p.hold
+}
+
+func (p *spamPolicy) needsStats() bool {
+	return true
+}
+
+// input in 'lagging' state, as defined by 'syncLag' parameters
+type outOfSyncPolicy struct {
+	hold time.Duration
+}
+
+func (p *outOfSyncPolicy) name() string {
+	return "outOfSync"
+}
+
+func (p *outOfSyncPolicy) check(st *ZmqRoutineStats, ctx *valveContext) string {
+	if st.State != "lagging" {
+		return ""
+	}
+	return fmt.Sprintf("outOfSync: %v milestones behind, not advanced for %v sec", st.LmiLag, st.LmiStaleSec)
+}
+
+func (p *outOfSyncPolicy) holdTime() time.Duration {
+	return p.hold
+}
+
+func (p *outOfSyncPolicy) needsStats() bool {
+	return false
+}
+
+// input which sees many transactions nobody else sees
+type seenOncePolicy struct {
+	maxRate float64
+	hold    time.Duration
+}
+
+func (p *seenOncePolicy) name() string {
+	return "seenOnce"
+}
+
+func (p *seenOncePolicy) check(st *ZmqRoutineStats, ctx *valveContext) string {
+	if float64(st.SeenOnceRate) <= p.maxRate {
+		return ""
+	}
+	return fmt.Sprintf("seenOnce: seen once rate %v%% > %v%%", st.SeenOnceRate, p.maxRate)
+}
+
+func (p *seenOncePolicy) holdTime() time.Duration {
+	return p.hold
+}
+
+func (p *seenOncePolicy) needsStats() bool {
+	return true
+}
+
+// input which delivers transactions much later than others
+type latencyPolicy struct {
+	maxLatencySec float64
+	hold          time.Duration
+}
+
+func (p *latencyPolicy) name() string {
+	return "latency"
+}
+
+func (p *latencyPolicy) check(st *ZmqRoutineStats, ctx *valveContext) string {
+	if st.AvgLatencySec <= p.maxLatencySec {
+		return ""
+	}
+	return fmt.Sprintf("latency: average latency %v sec > %v sec", st.AvgLatencySec, p.maxLatencySec)
+}
+
+func (p *latencyPolicy) holdTime() time.Duration {
+	return p.hold
+}
+
+func (p *latencyPolicy) needsStats() bool {
+	return true
+}
@@ -1,34 +1,56 @@
 package inputpart
 
 import (
-	"fmt"
 	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/ha"
+	"strconv"
+	"strings"
 )
 
 func toOutput(msgData []byte, msgSplit []string) {
 	// publish message to output Nanomsg channel exactly as received from ZeroMQ. For others to consume
-	if err := compoundOutPublisher.PublishData(msgData); err != nil {
-		errorf("Error while publishing data: %v", err)
+	// Standby instance only keeps its caches and metrics warm
+	if ha.IsLeader() {
+		if err := compoundOutPublisher.PublishData(msgData); err != nil {
+			errorf("Error while publishing data: %v", err)
+		}
+		// same message to ZMQ output and WebSocket clients
+		publishZMQ(msgData)
+		publishWS(msgData, msgSplit)
 	}
 	// update metrics based on compound (resulting) message stream (TPS, CTPS etc)
 	updateCompoundMetrics(msgSplit[0])
 	// analyze if this is value transaction. Process to collect necessary metrics
 	processValueTxMsg(msgSplit)
+	// notifications about watched addresses
+	processWatchList(msgSplit)
+	// filtered derived topics
+	processOutFilters(msgSplit)
 }
 
 // forming new message type
-// 'seen <tx_hash> <quorum filter level passed>'
+// 'seen <tx_hash> <quorum filter level passed> <path>'. Path is own instance id and ids of upstreams, see federation
 
 func publishQuorumUpdate(txHash string, timesSeen int) {
-	if !cfg.Config.QuorumUpdatesEnabled {
+	c := cfg.Get()
+	if !c.QuorumUpdatesEnabled {
 		return
 	}
-	if timesSeen < cfg.Config.QuorumUpdatesFrom || timesSeen > cfg.Config.QuorumUpdatesTo {
+	if timesSeen < c.QuorumUpdatesFrom || timesSeen > c.QuorumUpdatesTo {
 		return
 	}
-	msgData := fmt.Sprintf("seen %s %d", txHash, timesSeen)
+	publishDerived("seen", txHash, strconv.Itoa(timesSeen), getFederationPath())
+}
 
-	if err := compoundOutPublisher.PublishData([]byte(msgData)); err != nil {
+// publishes message produced by tanglebeat itself (not received from inputs) to all outputs
+func publishDerived(msgSplit ...string) {
+	if !ha.IsLeader() {
+		return
+	}
+	msgData := []byte(strings.Join(msgSplit, " "))
+	if err := compoundOutPublisher.PublishData(msgData); err != nil {
 		errorf("Error while publishing data: %v", err)
 	}
+	publishZMQ(msgData)
+	publishWS(msgData, msgSplit)
 }
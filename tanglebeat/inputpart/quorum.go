@@ -1,15 +1,66 @@
 package inputpart
 
-import "github.com/unioproject/tanglebeat/tanglebeat/cfg"
+import (
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"sync"
+	"time"
+)
+
+// in adaptive mode effective quorum is lowered to the number of running inputs
+// if less inputs are running than quorum requires
+
+var (
+	numRunningInputs      int
+	numRunningInputsMutex = &sync.RWMutex{}
+)
+
+const adaptiveQuorumLoopSleepSec = 5
+
+func startAdaptiveQuorumRoutine() {
+	go adaptiveQuorumLoop()
+	infof("Started 'adaptiveQuorumLoop'")
+}
+
+func adaptiveQuorumLoop() {
+	var prevTx, prevSn, prevLmi int
+	for {
+		n := inputRoutines.NumRunning()
+		numRunningInputsMutex.Lock()
+		numRunningInputs = n
+		numRunningInputsMutex.Unlock()
+
+		tx, sn, lmi := GetTxQuorum(), GetSnQuorum(), GetLmiQuorum()
+		if tx != prevTx || sn != prevSn || lmi != prevLmi {
+			infof("Effective quorum: tx = %v, sn = %v, lmi = %v. Running inputs: %v", tx, sn, lmi, n)
+			prevTx, prevSn, prevLmi = tx, sn, lmi
+		}
+		time.Sleep(adaptiveQuorumLoopSleepSec * time.Second)
+	}
+}
+
+func adaptQuorum(quorum int) int {
+	if !cfg.Get().AdaptiveQuorum {
+		return quorum
+	}
+	numRunningInputsMutex.RLock()
+	defer numRunningInputsMutex.RUnlock()
+	if numRunningInputs >= quorum {
+		return quorum
+	}
+	if numRunningInputs < 1 {
+		return 1
+	}
+	return numRunningInputs
+}
 
 func GetTxQuorum() int {
-	return cfg.Config.QuorumTxToPass
+	return adaptQuorum(cfg.Get().QuorumTxToPass)
 }
 
 func GetSnQuorum() int {
-	return 3
+	return adaptQuorum(cfg.Get().QuorumSnToPass)
 }
 
 func GetLmiQuorum() int {
-	return 3
+	return adaptQuorum(cfg.Get().QuorumLmiToPass)
 }
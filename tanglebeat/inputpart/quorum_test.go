@@ -0,0 +1,190 @@
+package inputpart
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/unioproject/tanglebeat/lib/irisim"
+	"github.com/unioproject/tanglebeat/lib/nanomsg"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/inreaders"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// end-to-end test of the filter: messages of simulated nodes go through the filter synchronously,
+// messages passed the quorum are counted by compound metrics
+
+var (
+	initFilterOnce sync.Once
+	simRuns        int // caches are global: each run has its own seed, milestone index and ids of sources
+)
+
+func initFilterForTest(t *testing.T) {
+	initFilterOnce.Do(func() {
+		cfg.Set(&cfg.ConfigStructYAML{
+			RetentionPeriodMin: 60,
+			QuorumTxToPass:     2,
+			QuorumSnToPass:     2,
+			QuorumLmiToPass:    1,
+		})
+		initZmqMetrics()
+		initMsgFilter()
+		initValueTx()
+		initWatchList()
+		initOutFilters()
+		startEchoLatencyRoutine()
+		var err error
+		if compoundOutPublisher, err = nanomsg.NewPublisherTLS(false, 0, 0, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func setQuorumsForTest(tx, sn int) {
+	c := new(cfg.ConfigStructYAML)
+	*c = *cfg.Get()
+	c.QuorumTxToPass = tx
+	c.QuorumSnToPass = sn
+	cfg.Set(c)
+}
+
+type filterSink struct {
+	routine *inputRoutine
+	clock   *irisim.ManualClock
+}
+
+func (s *filterSink) Send(msg []byte) error {
+	ts := uint64(s.clock.Now().UnixNano() / int64(time.Millisecond))
+	filterMsg(s.routine, msg, strings.Split(string(msg), " "), ts)
+	return nil
+}
+
+func (s *filterSink) Close() {}
+
+// runs simulated nodes for 'd' and returns simulator stats and number of tx and sn messages passed the quorum
+func runSimulatedNodes(t *testing.T, nodes []irisim.NodeParams, d time.Duration) (irisim.Stats, int, int) {
+	simRuns++
+	clock := irisim.NewManualClock(time.Now())
+	sinks := make([]irisim.MsgSink, len(nodes))
+	for i := range nodes {
+		r := &inputRoutine{
+			InputReaderBase:  *inreaders.NewInputReaderBase(),
+			inputStreamType:  inputStreamReplaySource,
+			uri:              fmt.Sprintf("sim-%v-%v", simRuns, i),
+			weight:           1,
+			autoWeightFactor: 1,
+		}
+		r.SetId__(byte(simRuns*len(nodes) + i))
+		sinks[i] = &filterSink{routine: r, clock: clock}
+	}
+	sim, err := irisim.NewSimulatorWithSinks(irisim.Params{
+		Nodes:                nodes,
+		TPS:                  50,
+		ConfirmationRate:     0.5,
+		ValueBundleRate:      0.2,
+		MilestoneIntervalSec: 1,
+		StartMilestoneIndex:  simRuns * 10000,
+		Seed:                 int64(simRuns),
+		Clock:                clock,
+	}, sinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txBefore := testutil.ToFloat64(zmqMetricsTxCounterCompound)
+	snBefore := testutil.ToFloat64(zmqMetricsCtxCounterCompound)
+	if err = sim.Advance(d); err != nil {
+		t.Fatal(err)
+	}
+	return sim.GetStats(),
+		int(testutil.ToFloat64(zmqMetricsTxCounterCompound) - txBefore),
+		int(testutil.ToFloat64(zmqMetricsCtxCounterCompound) - snBefore)
+}
+
+func Test_QuorumSimulated(t *testing.T) {
+	initFilterForTest(t)
+
+	// two good nodes and one which loses everything: quorum 2 is reached by every message
+	nodes := []irisim.NodeParams{{}, {}, {DropRate: 1}}
+	setQuorumsForTest(2, 2)
+	stats, txPassed, snPassed := runSimulatedNodes(t, nodes, 5*time.Second)
+	if stats.NumTx == 0 || stats.NumConfirmedTx == 0 {
+		t.Fatalf("simulator generated nothing: %+v", stats)
+	}
+	if uint64(txPassed) != stats.NumTx {
+		t.Errorf("quorum 2: expected %v tx passed, got %v", stats.NumTx, txPassed)
+	}
+	if uint64(snPassed) != stats.NumConfirmedTx {
+		t.Errorf("quorum 2: expected %v sn passed, got %v", stats.NumConfirmedTx, snPassed)
+	}
+	// filter takes the first index of 'lmi <previous> <latest>'
+	if getLmiPassed() != stats.MilestoneIndex-1 {
+		t.Errorf("expected milestone %v passed, got %v", stats.MilestoneIndex-1, getLmiPassed())
+	}
+
+	// quorum 3 can't be reached with two sources
+	setQuorumsForTest(3, 3)
+	stats, txPassed, snPassed = runSimulatedNodes(t, nodes, 5*time.Second)
+	if stats.NumTx == 0 || txPassed != 0 || snPassed != 0 {
+		t.Errorf("quorum 3: expected nothing passed of %v tx, got %v tx and %v sn", stats.NumTx, txPassed, snPassed)
+	}
+}
+
+func Test_LmiQuorum(t *testing.T) {
+	initFilterForTest(t)
+	const numInputs = 3
+	c := new(cfg.ConfigStructYAML)
+	*c = *cfg.Get()
+	saved := c.QuorumLmiToPass
+	c.QuorumLmiToPass = numInputs
+	cfg.Set(c)
+	defer func() {
+		c := new(cfg.ConfigStructYAML)
+		*c = *cfg.Get()
+		c.QuorumLmiToPass = saved
+		cfg.Set(c)
+	}()
+
+	inputs := make([]*inputRoutine, numInputs)
+	for i := range inputs {
+		inputs[i] = &inputRoutine{
+			InputReaderBase: *inreaders.NewInputReaderBase(),
+			uri:             fmt.Sprintf("lmi-%v", i),
+		}
+		inputs[i].SetId__(byte(100 + i))
+	}
+	simRuns++
+	index := simRuns*10000 + 5000
+	msg := fmt.Sprintf("lmi %v %v", index, index+1)
+	lmi := func(r *inputRoutine) {
+		filterLMIMsg(r, []byte(msg), strings.Split(msg, " "), 0)
+	}
+	// repeats of the same input don't count
+	for i := 0; i < numInputs; i++ {
+		lmi(inputs[0])
+	}
+	for _, r := range inputs[1 : numInputs-1] {
+		lmi(r)
+	}
+	if getLmiPassed() == index {
+		t.Fatalf("lmi passed before seen by %v inputs", numInputs)
+	}
+	lmi(inputs[numInputs-1])
+	if getLmiPassed() != index {
+		t.Fatalf("lmi seen by %v inputs didn't pass quorum %v", numInputs, numInputs)
+	}
+	// passed lmi is not released again
+	lmiMutex.Lock()
+	lastLMIPassed = 0
+	lmiMutex.Unlock()
+	for _, r := range inputs {
+		lmi(r)
+	}
+	if getLmiPassed() != 0 {
+		t.Errorf("lmi released more than once")
+	}
+	lmiMutex.Lock()
+	lastLMIPassed = index
+	lmiMutex.Unlock()
+}
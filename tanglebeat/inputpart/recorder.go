@@ -0,0 +1,225 @@
+package inputpart
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// recorder of raw input messages. Each message received by input routine is written
+// to the gzip compressed file together with source uri and arrival timestamp.
+// Files are rotated every 'recordRotateMin' minutes.
+// Recorded files are replayed by input routine of type inputStreamReplay
+//
+// record format (big endian):
+//   8 bytes  arrival timestamp, unix ms
+//   2 bytes  length of the uri
+//   uri
+//   4 bytes  length of the message
+//   message
+
+const (
+	recordFilePrefix           = "tbrec-"
+	recordFileSuffix           = ".gz"
+	recorderChanBufLen         = 1000
+	recorderLostLogIntervalSec = 60
+)
+
+type msgRecord struct {
+	ts  uint64
+	uri string
+	msg []byte
+}
+
+var (
+	recorderChan    chan *msgRecord
+	recorderStopped chan struct{}
+	recorderClosed  bool
+	recorderMutex   = &sync.RWMutex{}
+	recorderLost    uint64 // messages lost because the buffer was full
+	recorderLostLog int64  // unix sec of the last log about lost messages
+)
+
+func writeRecord(w io.Writer, rec *msgRecord) error {
+	var hdr [10]byte
+	binary.BigEndian.PutUint64(hdr[:8], rec.ts)
+	binary.BigEndian.PutUint16(hdr[8:], uint16(len(rec.uri)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, rec.uri); err != nil {
+		return err
+	}
+	var lenbuf [4]byte
+	binary.BigEndian.PutUint32(lenbuf[:], uint32(len(rec.msg)))
+	if _, err := w.Write(lenbuf[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(rec.msg)
+	return err
+}
+
+// returns io.EOF at the end of the file
+func readRecord(r io.Reader) (*msgRecord, error) {
+	var hdr [10]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	ret := &msgRecord{ts: binary.BigEndian.Uint64(hdr[:8])}
+	uri := make([]byte, binary.BigEndian.Uint16(hdr[8:]))
+	if _, err := io.ReadFull(r, uri); err != nil {
+		return nil, err
+	}
+	ret.uri = string(uri)
+	var lenbuf [4]byte
+	if _, err := io.ReadFull(r, lenbuf[:]); err != nil {
+		return nil, err
+	}
+	ret.msg = make([]byte, binary.BigEndian.Uint32(lenbuf[:]))
+	if _, err := io.ReadFull(r, ret.msg); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func startRecorder() {
+	if cfg.Config.RecordDir == "" {
+		return
+	}
+	if err := os.MkdirAll(cfg.Config.RecordDir, 0755); err != nil {
+		errorf("Recorder is disabled: %v", err)
+		return
+	}
+	recorderChan = make(chan *msgRecord, recorderChanBufLen)
+	recorderStopped = make(chan struct{})
+	go recorderLoop(cfg.Config.RecordDir,
+		time.Duration(cfg.Config.RecordRotateMin)*time.Minute, cfg.Config.RecordMaxFiles)
+	infof("Started recorder of input messages to directory '%v'", cfg.Config.RecordDir)
+}
+
+// recordMsg doesn't block. If recorder is too slow, message is lost.
+// Lost messages are logged once a minute, not to flood the log when the disk is slow
+func recordMsg(uri string, msg []byte, ts uint64) {
+	recorderMutex.RLock()
+	defer recorderMutex.RUnlock()
+	if recorderChan == nil || recorderClosed {
+		return
+	}
+	select {
+	case recorderChan <- &msgRecord{ts: ts, uri: uri, msg: msg}:
+	default:
+		lost := atomic.AddUint64(&recorderLost, 1)
+		nowis := time.Now().Unix()
+		last := atomic.LoadInt64(&recorderLostLog)
+		if nowis-last >= recorderLostLogIntervalSec && atomic.CompareAndSwapInt64(&recorderLostLog, last, nowis) {
+			errorf("Recorder: buffer is full, %v messages were not recorded since start. Last from %v", lost, uri)
+		}
+	}
+}
+
+// StopRecorder flushes and closes current file of the recorder
+func StopRecorder() {
+	recorderMutex.Lock()
+	if recorderChan == nil || recorderClosed {
+		recorderMutex.Unlock()
+		return
+	}
+	recorderClosed = true
+	close(recorderChan)
+	recorderMutex.Unlock()
+
+	<-recorderStopped
+}
+
+type recordFile struct {
+	fout *os.File
+	zw   *gzip.Writer
+	bw   *bufio.Writer
+}
+
+func createRecordFile(dir string) (*recordFile, error) {
+	fname := path.Join(dir, recordFilePrefix+time.Now().Format("20060102-150405")+recordFileSuffix)
+	fout, err := os.Create(fname)
+	if err != nil {
+		return nil, fmt.Errorf("recorder: %v", err)
+	}
+	infof("Recorder: writing to '%v'", fname)
+	ret := &recordFile{fout: fout}
+	ret.zw = gzip.NewWriter(fout)
+	ret.bw = bufio.NewWriter(ret.zw)
+	return ret, nil
+}
+
+func (f *recordFile) close() {
+	if err := f.bw.Flush(); err != nil {
+		errorf("Recorder: %v", err)
+	}
+	if err := f.zw.Close(); err != nil {
+		errorf("Recorder: %v", err)
+	}
+	_ = f.fout.Close()
+}
+
+func recorderLoop(dir string, rotateEvery time.Duration, maxFiles int) {
+	defer close(recorderStopped)
+
+	var current *recordFile
+	var err error
+	var rotateAt time.Time
+	for rec := range recorderChan {
+		if current == nil || time.Now().After(rotateAt) {
+			if current != nil {
+				current.close()
+				current = nil
+			}
+			removeOldRecordFiles(dir, maxFiles-1)
+			if current, err = createRecordFile(dir); err != nil {
+				errorf("%v", err)
+				continue
+			}
+			rotateAt = time.Now().Add(rotateEvery)
+		}
+		if err = writeRecord(current.bw, rec); err != nil {
+			errorf("Recorder: %v", err)
+		}
+	}
+	if current != nil {
+		current.close()
+	}
+}
+
+func getRecordFiles(pattern string) []string {
+	ret, err := filepath.Glob(pattern)
+	if err != nil {
+		errorf("Wrong file pattern '%v': %v", pattern, err)
+		return nil
+	}
+	// names contain timestamp, so sorted names are in chronological order
+	sort.Strings(ret)
+	return ret
+}
+
+// leaves not more than 'leave' latest files. leave < 0 means no limit
+func removeOldRecordFiles(dir string, leave int) {
+	if leave < 0 {
+		return
+	}
+	files := getRecordFiles(path.Join(dir, recordFilePrefix+"*"+recordFileSuffix))
+	for i := 0; i < len(files)-leave; i++ {
+		if err := os.Remove(files[i]); err != nil {
+			errorf("Recorder: %v", err)
+		} else {
+			infof("Recorder: removed old file '%v'", files[i])
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package inputpart
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/inreaders"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// replay of files written by the recorder.
+// Uri of the replay input is a file name or a glob pattern, for example 'records/tbrec-*.gz'
+// For each source uri found in files the replay input creates input routine of type
+// inputStreamReplaySource named 'replay:<uri>', so it never is confused with the live input with the same uri.
+// Those routines do not read anything themselves, replay input passes messages to the filter
+// on their behalf in the original order and with recorded timestamps.
+// So quorum, metrics and stats are working the same way as with live inputs.
+// Timing is controlled by 'replaySpeed': 1 (default) means original timing, 10 - 10 times faster,
+// negative - as fast as possible.
+// When replay ends, source routines are disabled. They are enabled again if the replay is restarted
+
+const (
+	waitReplaySourcesSec = 30
+	replaySourcePrefix   = "replay:"
+)
+
+func forEachRecord(files []string, callback func(rec *msgRecord) bool) error {
+	for _, fname := range files {
+		stop, err := forEachRecordInFile(fname, callback)
+		if err != nil {
+			return err
+		}
+		if stop {
+			return nil
+		}
+	}
+	return nil
+}
+
+// returns true if stopped by callback
+func forEachRecordInFile(fname string, callback func(rec *msgRecord) bool) (bool, error) {
+	fin, err := os.Open(fname)
+	if err != nil {
+		return false, err
+	}
+	defer fin.Close()
+	zr, err := gzip.NewReader(fin)
+	if err != nil {
+		return false, fmt.Errorf("%v: %v", fname, err)
+	}
+	r := bufio.NewReader(zr)
+	for {
+		rec, err := readRecord(r)
+		switch {
+		case err == io.EOF:
+			return false, nil
+		case err == io.ErrUnexpectedEOF:
+			// file was not closed properly, for example the recording instance crashed
+			infof("Replay: file '%v' is truncated", fname)
+			return false, nil
+		case err != nil:
+			return false, fmt.Errorf("%v: %v", fname, err)
+		}
+		if !callback(rec) {
+			return true, nil
+		}
+	}
+}
+
+func (r *inputRoutine) isInitialized() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.initialized
+}
+
+// creates or finds input routines for all sources in the files and waits until all are running.
+// Routines created so far are returned also in case of error
+func (r *inputRoutine) prepareReplaySources(files []string) (map[string]*inputRoutine, error) {
+	ret := make(map[string]*inputRoutine)
+	err := forEachRecord(files, func(rec *msgRecord) bool {
+		if _, ok := ret[rec.uri]; ok {
+			return true
+		}
+		name := replaySourcePrefix + rec.uri
+		if !createInputRoutine(name, inputStreamReplaySource) {
+			// left from the previous run of the replay
+			if err := inputRoutines.EnableInputReader(name); err != nil {
+				errorf("Replay '%v': %v", r.uri, err)
+			}
+		}
+		ir, ok := inputRoutines.Get(name)
+		if !ok || ir.(*inputRoutine).inputStreamType != inputStreamReplaySource {
+			errorf("Replay '%v': can't create source routine '%v'", r.uri, name)
+			return true
+		}
+		ret[rec.uri] = ir.(*inputRoutine)
+		return true
+	})
+	if err != nil {
+		return ret, err
+	}
+	infof("Replay '%v': %v sources found. Waiting for source routines to start", r.uri, len(ret))
+	deadline := time.Now().Add(waitReplaySourcesSec * time.Second)
+	for uri, src := range ret {
+		for !src.isInitialized() {
+			if r.IsDisabled() {
+				return ret, fmt.Errorf("replay '%v' was stopped", r.uri)
+			}
+			if time.Now().After(deadline) {
+				return ret, fmt.Errorf("replay '%v': source routine '%v' didn't start", r.uri, uri)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return ret, nil
+}
+
+// sleeps until it is time to pass the record. Returns false if replay was stopped meanwhile
+func (r *inputRoutine) waitReplayTime(started time.Time, firstTs uint64, rec *msgRecord) bool {
+	speed := cfg.Get().ReplaySpeed
+	if speed <= 0 || rec.ts < firstTs {
+		return !r.IsDisabled()
+	}
+	due := started.Add(time.Duration(float64(rec.ts-firstTs)/speed) * time.Millisecond)
+	for {
+		if r.IsDisabled() {
+			return false
+		}
+		d := time.Until(due)
+		if d <= 0 {
+			return true
+		}
+		if d > time.Second {
+			d = time.Second
+		}
+		time.Sleep(d)
+	}
+}
+
+func (r *inputRoutine) runReplay() inreaders.ReasonNotRunning {
+	files := getRecordFiles(r.uri)
+	if len(files) == 0 {
+		errorf("Replay: no files found for '%v'", r.uri)
+		r.SetLastErr(fmt.Sprintf("no files found for '%v'", r.uri))
+		return inreaders.REASON_NORUN_ONHOLD_10MIN
+	}
+	sources, err := r.prepareReplaySources(files)
+	defer stopReplaySources(sources)
+	if err != nil {
+		errorf("%v", err)
+		r.SetLastErr(fmt.Sprintf("%v", err))
+		if r.IsDisabled() {
+			return inreaders.REASON_NORUN_DISABLED
+		}
+		return inreaders.REASON_NORUN_ERROR
+	}
+	r.SetReading(true)
+	infof("Replay '%v': started. Files: %v, speed: %v", r.uri, len(files), cfg.Get().ReplaySpeed)
+
+	var started time.Time
+	var firstTs uint64
+	var count int
+	err = forEachRecord(files, func(rec *msgRecord) bool {
+		if count == 0 {
+			started = time.Now()
+			firstTs = rec.ts
+		}
+		if !r.waitReplayTime(started, firstTs, rec) {
+			return false
+		}
+		count++
+		r.SetLastHeartbeatNow()
+		src, ok := sources[rec.uri]
+		if !ok {
+			return true
+		}
+		src.SetLastHeartbeatNow()
+		msgSplit := strings.Split(string(rec.msg), " ")
+		if expectedTopic(msgSplit[0]) {
+			toFilter(src, rec.msg, msgSplit, rec.ts)
+		}
+		return true
+	})
+	if err != nil {
+		errorf("Replay '%v': %v", r.uri, err)
+		r.SetLastErr(fmt.Sprintf("%v", err))
+	}
+	if r.IsDisabled() {
+		infof("Replay '%v': stopped after %v messages", r.uri, count)
+		return inreaders.REASON_NORUN_DISABLED
+	}
+	infof("Replay '%v': finished. %v messages replayed", r.uri, count)
+	// replay is run once
+	r.SetDisabled(true)
+	return inreaders.REASON_NORUN_DISABLED
+}
+
+// source routines are not running when there is nothing to replay
+func stopReplaySources(sources map[string]*inputRoutine) {
+	for uri := range sources {
+		if err := inputRoutines.DisableInputReader(replaySourcePrefix + uri); err != nil {
+			errorf("Replay: %v", err)
+		}
+	}
+}
+
+// replay source routine doesn't read anything. Socket only blocks until closed
+
+type replaySourceSocket struct {
+	uri    string
+	closed chan struct{}
+}
+
+func newReplaySourceSocket(uri string) inSocket {
+	return &replaySourceSocket{
+		uri:    uri,
+		closed: make(chan struct{}),
+	}
+}
+
+func (s *replaySourceSocket) RecvMsg() ([]byte, []string, error) {
+	<-s.closed
+	return nil, nil, fmt.Errorf("replay source '%v' closed", s.uri)
+}
+
+func (s *replaySourceSocket) Close() {
+	close(s.closed)
+}
@@ -0,0 +1,224 @@
+package inputpart
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/hashcache"
+	"os"
+	"sync"
+	"time"
+)
+
+// periodic snapshots of txcache, sncache, lmhsCache and transferBundleCache to the file.
+// Caches are restored from the snapshot upon start, so stats are correct right after restart.
+// Caches keep byte ids of inputs. Ids depend on the order inputs were added, so uri->id table is saved
+// after caches. Upon restart inputs get the same ids again
+
+const snapshotVersion = 2
+
+type snapshotHeader struct {
+	Version int
+	SavedAt uint64
+}
+
+type snapshotInputIds struct {
+	Ids    map[string]byte
+	NextId int
+}
+
+var snapshotMutex = &sync.Mutex{}
+
+func init() {
+	gob.Register(&transferBundleData{})
+}
+
+// transferBundleData has unexported fields, so it is encoded through the exported copy
+
+type bundleEntrySnapshot struct {
+	Addr  string
+	Value int64
+}
+
+type transferBundleDataSnapshot struct {
+	Hash          string
+	BundleHash    string
+	Entries       []bundleEntrySnapshot
+	Inconsistent  bool
+	Counted       bool
+	PostedValue   int64
+	Posted        bool
+	Confirmed     bool
+	WhenConfirmed uint64
+	NumUpdate     int
+}
+
+func (d *transferBundleData) GobEncode() ([]byte, error) {
+	snap := transferBundleDataSnapshot{
+		Hash:          d.hash,
+		BundleHash:    d.bundleHash,
+		Entries:       make([]bundleEntrySnapshot, len(d.entries)),
+		Inconsistent:  d.inconsistent,
+		Counted:       d.counted,
+		PostedValue:   d.postedValue,
+		Posted:        d.posted,
+		Confirmed:     d.confirmed,
+		WhenConfirmed: d.whenConfirmed,
+		NumUpdate:     d.numUpdate,
+	}
+	for i := range d.entries {
+		snap.Entries[i] = bundleEntrySnapshot{Addr: d.entries[i].addr, Value: d.entries[i].value}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *transferBundleData) GobDecode(data []byte) error {
+	var snap transferBundleDataSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+		return err
+	}
+	d.hash = snap.Hash
+	d.bundleHash = snap.BundleHash
+	d.entries = make([]bundleEntry, len(snap.Entries))
+	for i := range snap.Entries {
+		d.entries[i] = bundleEntry{addr: snap.Entries[i].Addr, value: snap.Entries[i].Value}
+	}
+	d.inconsistent = snap.Inconsistent
+	d.counted = snap.Counted
+	d.postedValue = snap.PostedValue
+	d.posted = snap.Posted
+	d.confirmed = snap.Confirmed
+	d.whenConfirmed = snap.WhenConfirmed
+	d.numUpdate = snap.NumUpdate
+	return nil
+}
+
+func snapshotCaches() []*hashcache.HashCacheBase {
+	return []*hashcache.HashCacheBase{
+		txcache, &sncache.HashCacheBase, lmhsCache, &transferBundleCache.HashCacheBase,
+	}
+}
+
+// SaveCacheSnapshot writes snapshot to the temporary file first, then renames it
+func SaveCacheSnapshot() error {
+	fname := cfg.Get().SnapshotFile
+	if fname == "" {
+		return nil
+	}
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	start := time.Now()
+	tmpname := fname + ".tmp"
+	fout, err := os.Create(tmpname)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %v", err)
+	}
+	zw := gzip.NewWriter(fout)
+	enc := gob.NewEncoder(zw)
+
+	err = enc.Encode(&snapshotHeader{Version: snapshotVersion, SavedAt: utils.UnixMsNow()})
+	for _, cache := range snapshotCaches() {
+		if err != nil {
+			break
+		}
+		err = cache.SaveSnapshot(enc)
+	}
+	if err == nil {
+		// after caches: inputs added while saving are included
+		var inputIds snapshotInputIds
+		inputIds.Ids, inputIds.NextId = inputRoutines.GetIds()
+		err = enc.Encode(&inputIds)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if errClose := fout.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		_ = os.Remove(tmpname)
+		return fmt.Errorf("failed to save snapshot to '%v': %v", fname, err)
+	}
+	if err = os.Rename(tmpname, fname); err != nil {
+		return fmt.Errorf("failed to save snapshot to '%v': %v", fname, err)
+	}
+	debugf("Cache snapshot saved to '%v' in %v", fname, time.Since(start))
+	return nil
+}
+
+func loadCacheSnapshot() {
+	fname := cfg.Get().SnapshotFile
+	if fname == "" {
+		return
+	}
+	fin, err := os.Open(fname)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			errorf("Failed to open snapshot file '%v': %v", fname, err)
+		}
+		return
+	}
+	defer fin.Close()
+
+	zr, err := gzip.NewReader(fin)
+	if err != nil {
+		errorf("Failed to read snapshot file '%v': %v", fname, err)
+		return
+	}
+	dec := gob.NewDecoder(zr)
+	var hdr snapshotHeader
+	if err = dec.Decode(&hdr); err != nil {
+		errorf("Failed to read snapshot file '%v': %v", fname, err)
+		return
+	}
+	if hdr.Version != snapshotVersion {
+		errorf("Snapshot file '%v' ignored: wrong version %v", fname, hdr.Version)
+		return
+	}
+	infof("Loading cache snapshot from '%v' saved %v sec ago", fname, utils.SinceUnixMs(hdr.SavedAt)/1000)
+	for _, cache := range snapshotCaches() {
+		numSeg, numEntries, err := cache.LoadSnapshot(dec)
+		if err != nil {
+			errorf("%v", err)
+			clearCaches()
+			return
+		}
+		infof("Restored '%v' from snapshot: %v segments, %v entries", cache.GetID(), numSeg, numEntries)
+	}
+	var inputIds snapshotInputIds
+	if err = dec.Decode(&inputIds); err != nil {
+		// ids in caches would be attributed to wrong inputs
+		errorf("Failed to read input ids from snapshot file '%v': %v. Snapshot ignored", fname, err)
+		clearCaches()
+		return
+	}
+	inputRoutines.RestoreIds(inputIds.Ids, inputIds.NextId, time.Unix(0, int64(hdr.SavedAt)*int64(time.Millisecond)))
+}
+
+func clearCaches() {
+	for _, cache := range snapshotCaches() {
+		cache.Clear()
+	}
+}
+
+func startSnapshotRoutine() {
+	go snapshotLoop()
+	infof("Started 'snapshotLoop'")
+}
+
+func snapshotLoop() {
+	for {
+		time.Sleep(time.Duration(cfg.Get().SnapshotPeriodMin) * time.Minute)
+		if err := SaveCacheSnapshot(); err != nil {
+			errorf("%v", err)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package inputpart
 
 import (
-	"github.com/unioproject/tanglebeat/lib/utils"
 	"github.com/unioproject/tanglebeat/tanglebeat/hashcache"
 )
 
@@ -20,7 +19,7 @@ func newHashCacheSN(hashLen int, segmentDurationSec int, retentionPeriodSec int)
 	return ret
 }
 
-func (cache *hashCacheSN) checkCurrentMilestoneIndex(index int, uri string) (bool, uint64) {
+func (cache *hashCacheSN) checkCurrentMilestoneIndex(index int, uri string, ts uint64) (bool, uint64) {
 	cache.Lock()
 	defer cache.Unlock()
 
@@ -34,7 +33,7 @@ func (cache *hashCacheSN) checkCurrentMilestoneIndex(index int, uri string) (boo
 	if cache.largestIndexCandidate == index && cache.largestIndexCandidateUri != uri {
 		debugf("------ milestone index changed %v --> %v ", cache.largestIndex, index)
 		cache.largestIndex = index
-		cache.indexChanged = utils.UnixMsNow()
+		cache.indexChanged = ts
 	} else {
 		cache.largestIndexCandidate = index
 		cache.largestIndexCandidateUri = uri
@@ -0,0 +1,239 @@
+package inputpart
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// feed of confirmed value transfers, as computed by 'calcTransfer'.
+// Last 'transfersFeedSize' transfers are kept in memory and are available with
+//    /api1/transfers         paged query and long poll
+//    /api1/transfers/stream  Server-Sent Events
+
+const (
+	transfersDefaultLimit = 100
+	transfersMaxLimit     = 1000
+	transfersMaxWaitSec   = 60
+	sseKeepAlivePeriod    = 30 * time.Second
+)
+
+type transferAddr struct {
+	Address string `json:"address"`
+	Value   int64  `json:"value"`
+}
+
+type confirmedTransfer struct {
+	Seq         uint64         `json:"seq"`
+	BundleHash  string         `json:"bundle"`
+	Value       int64          `json:"value"`
+	Remainder   int64          `json:"remainder"`
+	Inputs      []transferAddr `json:"inputs"`
+	Outputs     []transferAddr `json:"outputs"`
+	ConfirmedTs uint64         `json:"confirmedTs"`
+}
+
+type transfersResponse struct {
+	Transfers []*confirmedTransfer `json:"transfers"`
+	LastSeq   uint64               `json:"lastSeq"`
+}
+
+var (
+	transfers      = make([]*confirmedTransfer, 0)
+	transfersSeq   uint64
+	transfersMutex sync.RWMutex
+	// closed and replaced upon each new transfer, to wake up long polls and streams
+	chNewTransfer = make(chan struct{})
+)
+
+// called from 'updateBundleMetricsLoop' when bundle is counted as confirmed transfer
+func addConfirmedTransfer(data *transferBundleData) {
+	valueMoved, reminder, sumMap := calcTransfer(data)
+	if valueMoved == 0 {
+		return
+	}
+	tr := &confirmedTransfer{
+		BundleHash:  data.bundleHash,
+		Value:       valueMoved,
+		Remainder:   reminder,
+		Inputs:      make([]transferAddr, 0),
+		Outputs:     make([]transferAddr, 0),
+		ConfirmedTs: data.whenConfirmed,
+	}
+	for addr, v := range sumMap {
+		switch {
+		case v < 0:
+			tr.Inputs = append(tr.Inputs, transferAddr{Address: addr, Value: v})
+		case v > 0:
+			tr.Outputs = append(tr.Outputs, transferAddr{Address: addr, Value: v})
+		}
+	}
+	sort.Slice(tr.Inputs, func(i, j int) bool { return tr.Inputs[i].Address < tr.Inputs[j].Address })
+	sort.Slice(tr.Outputs, func(i, j int) bool { return tr.Outputs[i].Address < tr.Outputs[j].Address })
+
+	transfersMutex.Lock()
+	defer transfersMutex.Unlock()
+
+	transfersSeq++
+	tr.Seq = transfersSeq
+	transfers = append(transfers, tr)
+	if len(transfers) > cfg.Get().TransfersFeedSize {
+		transfers = transfers[len(transfers)-cfg.Get().TransfersFeedSize:]
+	}
+	close(chNewTransfer)
+	chNewTransfer = make(chan struct{})
+}
+
+// returns up to 'limit' transfers after 'since' and channel to wait for the new one
+func getTransfersSince(since uint64, limit int) ([]*confirmedTransfer, uint64, chan struct{}) {
+	transfersMutex.RLock()
+	defer transfersMutex.RUnlock()
+
+	idx := sort.Search(len(transfers), func(i int) bool { return transfers[i].Seq > since })
+	end := idx + limit
+	if end > len(transfers) {
+		end = len(transfers)
+	}
+	ret := make([]*confirmedTransfer, end-idx)
+	copy(ret, transfers[idx:end])
+	return ret, transfersSeq, chNewTransfer
+}
+
+// returns up to 'limit' transfers before 'before'. 0 means latest
+func getTransfersBefore(before uint64, limit int) ([]*confirmedTransfer, uint64) {
+	transfersMutex.RLock()
+	defer transfersMutex.RUnlock()
+
+	end := len(transfers)
+	if before != 0 {
+		end = sort.Search(len(transfers), func(i int) bool { return transfers[i].Seq >= before })
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	ret := make([]*confirmedTransfer, end-start)
+	copy(ret, transfers[start:end])
+	return ret, transfersSeq
+}
+
+func getUintParam(r *http.Request, name string, def uint64) (uint64, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	ret, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong value of '%v': %v", name, err)
+	}
+	return ret, nil
+}
+
+// HandlerTransfers returns confirmed transfers, oldest first.
+// 'limit' is max number of transfers to return, default 100. Greater than 1000 is reduced to 1000.
+// 'since' returns transfers with seq > since. With 'wait' it is long poll: if there are no such transfers,
+// waits up to 'wait' seconds (max 60) for the new one.
+// 'before' returns transfers with seq < before. Latest transfers if neither 'since' nor 'before' is specified
+func HandlerTransfers(w http.ResponseWriter, r *http.Request) {
+	var params [4]uint64
+	var err error
+	for i, p := range []string{"limit", "since", "before", "wait"} {
+		if params[i], err = getUintParam(r, p, 0); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if params[0] > transfersMaxLimit {
+		params[0] = transfersMaxLimit
+	}
+	limit, since, before, wait := int(params[0]), params[1], params[2], params[3]
+	if limit == 0 {
+		limit = transfersDefaultLimit
+	}
+	if wait > transfersMaxWaitSec {
+		wait = transfersMaxWaitSec
+	}
+	var resp transfersResponse
+	if r.URL.Query().Get("since") == "" {
+		resp.Transfers, resp.LastSeq = getTransfersBefore(before, limit)
+	} else {
+		var chNew chan struct{}
+		resp.Transfers, resp.LastSeq, chNew = getTransfersSince(since, limit)
+		if len(resp.Transfers) == 0 && wait > 0 {
+			select {
+			case <-chNew:
+				resp.Transfers, resp.LastSeq, _ = getTransfersSince(since, limit)
+			case <-time.After(time.Duration(wait) * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	data, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error while marshaling transfers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// HandlerTransfersStream streams confirmed transfers as Server-Sent Events.
+// Reconnecting client receives transfers missed since 'Last-Event-ID', if they are still in memory
+func HandlerTransfersStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	// new client receives only new transfers
+	_, lastSeq := getTransfersBefore(0, 0)
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+			lastSeq = n
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	debugf("SSE client %v connected to transfer stream", r.RemoteAddr)
+	keepAlive := time.NewTicker(sseKeepAlivePeriod)
+	defer keepAlive.Stop()
+	for {
+		trs, _, chNew := getTransfersSince(lastSeq, transfersMaxLimit)
+		for _, tr := range trs {
+			data, err := json.Marshal(tr)
+			if err != nil {
+				errorf("Error while marshaling transfer: %v", err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "id: %d\nevent: transfer\ndata: %s\n\n", tr.Seq, data); err != nil {
+				return
+			}
+			lastSeq = tr.Seq
+		}
+		flusher.Flush()
+		if len(trs) == transfersMaxLimit {
+			continue
+		}
+		select {
+		case <-chNew:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			debugf("SSE client %v disconnected from transfer stream", r.RemoteAddr)
+			return
+		}
+	}
+}
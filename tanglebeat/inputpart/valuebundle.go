@@ -18,14 +18,16 @@ type bundleEntry struct {
 }
 
 type transferBundleData struct {
-	hash         string
-	entries      []bundleEntry
-	inconsistent bool
-	counted      bool
-	postedValue  int64
-	posted       bool
-	confirmed    bool
-	numUpdate    int
+	hash          string
+	bundleHash    string
+	entries       []bundleEntry
+	inconsistent  bool
+	counted       bool
+	postedValue   int64
+	posted        bool
+	confirmed     bool
+	whenConfirmed uint64
+	numUpdate     int
 }
 
 var transferBundleCache *bundleCache
@@ -34,7 +36,7 @@ const segmentDurationBundleCacheSec = 10 * 60
 
 func initValueTx() {
 	transferBundleCache = newBundleCache(
-		useFirstHashTrytes, segmentDurationBundleCacheSec, cfg.Config.RetentionPeriodMin*60)
+		useFirstHashTrytes, segmentDurationBundleCacheSec, cfg.Get().RetentionPeriodMin*60)
 
 	go updateBundleMetricsLoop()
 }
@@ -86,8 +88,9 @@ func (cache *bundleCache) updateBundleData(bundleHash, addr string, value int64,
 	} else {
 		debugf("Bundle '%v' creating new bundle entry. Tx value = %v", bundleHash, value)
 		data = &transferBundleData{
-			hash:    shash,
-			entries: make([]bundleEntry, lastIdx+1, lastIdx+1),
+			hash:       shash,
+			bundleHash: bundleHash,
+			entries:    make([]bundleEntry, lastIdx+1, lastIdx+1),
 		}
 		data.entries[idx].addr = addr
 		data.entries[idx].value = value
@@ -111,19 +114,25 @@ func (cache *bundleCache) markConfirmed(bundleHash string) {
 	data = entry.Data.(*transferBundleData)
 	if !data.confirmed {
 		data.confirmed = true
+		data.whenConfirmed = utils.UnixMsNow()
 		debugf("Bundle %v... marked CONFIRMED", data.hash)
 	}
 }
 
-// returns: isBalanced, valueMoved
+// returns: valueMoved
 
 func sumBundle(data *transferBundleData) int64 {
-	var sum int64
-	var sumPos int64
-
 	if data.posted {
 		return data.postedValue
 	}
+	valueMoved, _, _ := calcTransfer(data)
+	return valueMoved
+}
+
+// returns value moved, reminder and net sums by address
+func calcTransfer(data *transferBundleData) (int64, int64, map[string]int64) {
+	var sum int64
+	var sumPos int64
 
 	for i := range data.entries {
 		sum += data.entries[i].value
@@ -133,7 +142,7 @@ func sumBundle(data *transferBundleData) int64 {
 	}
 	if sum != 0 {
 		// only makes sense if all tx balanced to zero
-		return 0
+		return 0, 0, nil
 	}
 	// collect net sums by address
 	sumMap := make(map[string]int64)
@@ -153,7 +162,7 @@ func sumBundle(data *transferBundleData) int64 {
 		}
 	}
 	if valueMoved == 0 {
-		return 0 // bundle doesn't move balances, it is fake transfer
+		return 0, 0, sumMap // bundle doesn't move balances, it is fake transfer
 	}
 	//  assume:
 	//  - reminder = last in the bundle if value > 0
@@ -170,9 +179,9 @@ func sumBundle(data *transferBundleData) int64 {
 		reminder = 0
 	}
 	if valueMoved <= reminder {
-		return 0
+		return 0, 0, sumMap
 	}
-	return valueMoved - reminder
+	return valueMoved - reminder, reminder, sumMap
 }
 
 func updateBundleMetricsLoop() {
@@ -203,6 +212,7 @@ func updateBundleMetricsLoop() {
 				data.counted = true
 				newConfirmedBundles++
 				debugf("++++++ Bundle %v...: counting new", data.hash)
+				addConfirmedTransfer(data)
 			}
 			data.postedValue = valueMoved
 			data.posted = true
@@ -0,0 +1,308 @@
+package inputpart
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/ha"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// watch list of addresses. When watched address appears in the quorum-passed 'tx' message
+// or is confirmed by 'sn' message, notification is produced:
+// - message 'watch <name> <tx|sn> <address> <tx hash> <bundle hash>' to the output stream
+// - POST of json to the webhook of the watch, if defined
+// - counter 'tanglebeat_watch_counter' is incremented
+// Watches come from the config file and can be added/removed at runtime with admin API.
+// Watches added at runtime are not saved
+
+const (
+	watchWebhookQueueSize = 1000
+	watchWebhookTimeout   = 10 * time.Second
+)
+
+type watch struct {
+	name       string
+	addresses  map[string]struct{}
+	webhook    string
+	fromConfig bool
+}
+
+type watchEvent struct {
+	Watch          string `json:"watch"`
+	Type           string `json:"type"`
+	Address        string `json:"address"`
+	TxHash         string `json:"txHash"`
+	Bundle         string `json:"bundle"`
+	Value          int64  `json:"value,omitempty"`
+	MilestoneIndex int    `json:"milestoneIndex,omitempty"`
+	Ts             uint64 `json:"ts"`
+}
+
+type webhookRequest struct {
+	url   string
+	event *watchEvent
+}
+
+type WatchInfo struct {
+	Name       string   `json:"name"`
+	Addresses  []string `json:"addresses"`
+	Webhook    string   `json:"webhook,omitempty"`
+	FromConfig bool     `json:"fromConfig"`
+}
+
+var (
+	watches       = make(map[string]*watch)
+	watchesByAddr = make(map[string][]*watch)
+	watchMutex    sync.RWMutex
+	chWebhook     = make(chan *webhookRequest, watchWebhookQueueSize)
+)
+
+func initWatchList() {
+	SetConfigWatchList(cfg.Get().WatchList)
+	go webhookLoop()
+}
+
+// address with checksum is accepted, checksum is ignored
+func normalizeAddress(addr string) (string, error) {
+	addr = strings.ToUpper(strings.TrimSpace(addr))
+	if len(addr) == 90 {
+		addr = addr[:81]
+	}
+	if len(addr) != 81 {
+		return "", fmt.Errorf("wrong address '%v': expected 81 or 90 trytes", addr)
+	}
+	return normalizeHash(addr, "address")
+}
+
+// 81 trytes, 'what' is for the error message
+func normalizeHash(hash string, what string) (string, error) {
+	hash = strings.ToUpper(strings.TrimSpace(hash))
+	if len(hash) != 81 {
+		return "", fmt.Errorf("wrong %v '%v': expected 81 trytes", what, hash)
+	}
+	for _, c := range hash {
+		if c != '9' && (c < 'A' || c > 'Z') {
+			return "", fmt.Errorf("wrong %v '%v': not trytes", what, hash)
+		}
+	}
+	return hash, nil
+}
+
+func rebuildWatchIndex__() {
+	watchesByAddr = make(map[string][]*watch)
+	for _, w := range watches {
+		for addr := range w.addresses {
+			watchesByAddr[addr] = append(watchesByAddr[addr], w)
+		}
+	}
+}
+
+// SetConfigWatchList replaces watches from the config file. Watches added at runtime remain,
+// unless watch with the same name is in the config
+func SetConfigWatchList(list []cfg.WatchYAML) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+
+	for name, w := range watches {
+		if w.fromConfig {
+			delete(watches, name)
+			deleteWatchCounter(name)
+		}
+	}
+	for _, wy := range list {
+		if err := checkWatchName(wy.Name); err != nil {
+			errorf("Watch list: %v. Watch ignored", err)
+			continue
+		}
+		w := &watch{
+			name:       wy.Name,
+			addresses:  make(map[string]struct{}),
+			webhook:    wy.Webhook,
+			fromConfig: true,
+		}
+		for _, a := range wy.Addresses {
+			addr, err := normalizeAddress(a)
+			if err != nil {
+				errorf("Watch '%v': %v", wy.Name, err)
+				continue
+			}
+			w.addresses[addr] = struct{}{}
+		}
+		watches[w.name] = w
+		infof("Watch '%v': %d addresses, webhook '%v'", w.name, len(w.addresses), w.webhook)
+	}
+	rebuildWatchIndex__()
+}
+
+// name is a field of the 'watch' message, so it can't contain spaces
+func checkWatchName(name string) error {
+	if name == "" {
+		return fmt.Errorf("watch name is empty")
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("watch name '%v' contains whitespace", name)
+	}
+	return nil
+}
+
+// AddWatch adds address to the watch. Watch is created if it doesn't exist.
+// Non empty webhook replaces webhook of the watch
+func AddWatch(name, address, webhook string) error {
+	if err := checkWatchName(name); err != nil {
+		return err
+	}
+	addr, err := normalizeAddress(address)
+	if err != nil {
+		return err
+	}
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+
+	w, ok := watches[name]
+	if !ok {
+		w = &watch{
+			name:      name,
+			addresses: make(map[string]struct{}),
+		}
+		watches[name] = w
+	}
+	if webhook != "" {
+		w.webhook = webhook
+	}
+	w.addresses[addr] = struct{}{}
+	rebuildWatchIndex__()
+	infof("Watch '%v': added address %v", name, addr)
+	return nil
+}
+
+// RemoveWatch removes address from the watch or the whole watch if address is empty
+func RemoveWatch(name, address string) error {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+
+	w, ok := watches[name]
+	if !ok {
+		return fmt.Errorf("watch '%v' doesn't exist", name)
+	}
+	if address == "" {
+		delete(watches, name)
+		deleteWatchCounter(name)
+		infof("Watch '%v' removed", name)
+	} else {
+		addr, err := normalizeAddress(address)
+		if err != nil {
+			return err
+		}
+		if _, ok = w.addresses[addr]; !ok {
+			return fmt.Errorf("address %v is not in watch '%v'", addr, name)
+		}
+		delete(w.addresses, addr)
+		infof("Watch '%v': removed address %v", name, addr)
+	}
+	rebuildWatchIndex__()
+	return nil
+}
+
+func GetWatchList() []*WatchInfo {
+	watchMutex.RLock()
+	defer watchMutex.RUnlock()
+
+	ret := make([]*WatchInfo, 0, len(watches))
+	for _, w := range watches {
+		wi := &WatchInfo{
+			Name:       w.name,
+			Addresses:  make([]string, 0, len(w.addresses)),
+			Webhook:    w.webhook,
+			FromConfig: w.fromConfig,
+		}
+		for addr := range w.addresses {
+			wi.Addresses = append(wi.Addresses, addr)
+		}
+		sort.Strings(wi.Addresses)
+		ret = append(ret, wi)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// called for each quorum-passed message
+func processWatchList(msgSplit []string) {
+	var addr, txHash, bundle string
+	var value int64
+	var msIndex int
+
+	switch msgSplit[0] {
+	case "tx":
+		if len(msgSplit) < 9 {
+			return
+		}
+		addr, txHash, bundle = msgSplit[2], msgSplit[1], msgSplit[8]
+		value, _ = strconv.ParseInt(msgSplit[3], 10, 64)
+	case "sn":
+		if len(msgSplit) < 7 {
+			return
+		}
+		addr, txHash, bundle = msgSplit[3], msgSplit[2], msgSplit[6]
+		msIndex, _ = strconv.Atoi(msgSplit[1])
+	default:
+		return
+	}
+	watchMutex.RLock()
+	defer watchMutex.RUnlock()
+
+	for _, w := range watchesByAddr[addr] {
+		debugf("Watch '%v': %v address %v, tx %v", w.name, msgSplit[0], addr, txHash)
+		publishDerived("watch", w.name, msgSplit[0], addr, txHash, bundle)
+		updateWatchCounter(w.name, msgSplit[0])
+		if w.webhook == "" || !ha.IsLeader() {
+			continue
+		}
+		req := &webhookRequest{
+			url: w.webhook,
+			event: &watchEvent{
+				Watch:          w.name,
+				Type:           msgSplit[0],
+				Address:        addr,
+				TxHash:         txHash,
+				Bundle:         bundle,
+				Value:          value,
+				MilestoneIndex: msIndex,
+				Ts:             utils.UnixMsNow(),
+			},
+		}
+		select {
+		case chWebhook <- req:
+		default:
+			errorf("Watch '%v': webhook queue is full, notification dropped", w.name)
+		}
+	}
+}
+
+func webhookLoop() {
+	client := &http.Client{Timeout: watchWebhookTimeout}
+	for req := range chWebhook {
+		data, err := json.Marshal(req.event)
+		if err != nil {
+			errorf("Watch '%v': %v", req.event.Watch, err)
+			continue
+		}
+		resp, err := client.Post(req.url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			errorf("Watch '%v': webhook '%v' failed: %v", req.event.Watch, req.url, err)
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			errorf("Watch '%v': webhook '%v' returned %v", req.event.Watch, req.url, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package inputpart
+
+import (
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/inreaders"
+	"math"
+	"time"
+)
+
+// trust weights of inputs for weighted quorum.
+// Weight of the input is taken from 'inputWeights' (default 1).
+// If 'autoWeights' is enabled, it is multiplied by the factor calculated from the seen once rate, share of obsolete
+// SN messages and average latency of the input. Factors are normalized to the mean of 1 across running inputs,
+// so weighted quorum needs the same number of average inputs as the plain one
+
+const (
+	minAutoWeightFactor = 0.1
+	latencyAlpha        = 0.01 // smoothing factor of the latency moving average
+	latencyToleranceSec = 1.0  // no penalty for average latency below this
+	weightEpsilon       = 1e-9 // sum of float weights may be a bit below the threshold it should reach
+	weightsLoopSleepSec = 10
+)
+
+func startWeightsRoutine() {
+	go weightsLoop()
+	infof("Started 'weightsLoop'")
+}
+
+func weightsLoop() {
+	for {
+		updateWeights()
+		time.Sleep(weightsLoopSleepSec * time.Second)
+	}
+}
+
+// recalculates auto weight factors and weights of all inputs
+func updateWeights() {
+	stats := make([]*ZmqRoutineStats, 0, 10)
+	inputRoutines.ForEach(func(name string, ir inreaders.InputReader) {
+		stats = append(stats, ir.(*inputRoutine).getStats())
+	})
+	meanFactor := meanAutoWeightFactor(stats)
+	for _, st := range stats {
+		st.routine.updateWeight(meanFactor)
+	}
+}
+
+func getStaticWeight(uri string) float64 {
+	if w, ok := cfg.Get().InputWeights[uri]; ok && w >= 0 {
+		return w
+	}
+	return 1
+}
+
+// factor is from minAutoWeightFactor to 1, the worse the input the lower
+func calcAutoWeightFactor(seenOnceRate uint64, obsoleteSnCount uint64, ctxCount uint64, avgLatencySec float64) float64 {
+	if !cfg.Get().AutoWeights {
+		return 1
+	}
+	factor := 1.0
+	if seenOnceRate <= 100 {
+		factor *= 1 - float64(seenOnceRate)/100
+	}
+	if obsoleteSnCount+ctxCount != 0 {
+		factor *= 1 - float64(obsoleteSnCount)/float64(obsoleteSnCount+ctxCount)
+	}
+	if avgLatencySec > latencyToleranceSec {
+		factor *= latencyToleranceSec / avgLatencySec
+	}
+	if factor < minAutoWeightFactor {
+		factor = minAutoWeightFactor
+	}
+	return factor
+}
+
+// mean of auto weight factors of running inputs
+func meanAutoWeightFactor(stats []*ZmqRoutineStats) float64 {
+	var sum float64
+	var n int
+	for _, st := range stats {
+		if st.Running {
+			sum += st.routine.getAutoWeightFactor()
+			n++
+		}
+	}
+	if n == 0 || sum == 0 {
+		return 1
+	}
+	return sum / float64(n)
+}
+
+func (r *inputRoutine) getAutoWeightFactor() float64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.autoWeightFactor
+}
+
+// sets weight of the input normalized by the mean factor of all running inputs
+func (r *inputRoutine) updateWeight(meanFactor float64) {
+	r.Lock()
+	defer r.Unlock()
+	r.weight = math.Round(100*getStaticWeight(r.uri)*r.autoWeightFactor/meanFactor) / 100
+}
+
+// releasing message exactly once: when sum of weights crosses the threshold
+func weightCrossed(entryWeight float64, weight float64, threshold int) bool {
+	t := float64(threshold) - weightEpsilon
+	return entryWeight >= t && entryWeight-weight < t
+}
+
+func (r *inputRoutine) getWeight() float64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.weight
+}
+
+func (r *inputRoutine) accountLatency(latencyMs uint64) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.initialized {
+		return
+	}
+	r.avgLatencySec = (1-latencyAlpha)*r.avgLatencySec + latencyAlpha*float64(latencyMs)/1000
+}
@@ -0,0 +1,260 @@
+package inputpart
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// output stream over WebSocket. Streams same messages as the Nanomsg output.
+// Client chooses topics and format with query parameters, for example
+//    ws://<host>:<webServerPort>/api1/stream?topics=tx,sn&format=json
+// 'topics' is comma separated list of 'tx', 'sn', 'lmi', 'lmhs', 'seen', 'watch' and filtered derived topics
+// like 'ftx.tag.TANGLE9BEAT', see outfilter. All topics except derived if omitted
+// 'format' is 'raw' (default, text exactly as in Nanomsg stream) or 'json' (parsed message with named fields)
+
+const (
+	wsClientBufferSize = 1000
+	wsWriteTimeout     = 10 * time.Second
+	wsPingPeriod       = 30 * time.Second
+)
+
+var wsTopics = []string{"tx", "sn", "lmi", "lmhs", "seen", "watch"}
+
+type wsField struct {
+	name  string
+	isInt bool
+}
+
+// names of the fields after topic, in the order of IRI ZMQ message
+var wsFields = map[string][]wsField{
+	"tx": {
+		{"hash", false}, {"address", false}, {"value", true}, {"obsoleteTag", false},
+		{"timestamp", true}, {"currentIndex", true}, {"lastIndex", true}, {"bundle", false},
+		{"trunk", false}, {"branch", false}, {"arrivalTime", true}, {"tag", false},
+	},
+	"sn": {
+		{"milestoneIndex", true}, {"hash", false}, {"address", false},
+		{"trunk", false}, {"branch", false}, {"bundle", false},
+	},
+	"lmi":  {{"prevMilestoneIndex", true}, {"milestoneIndex", true}},
+	"lmhs": {{"hash", false}},
+	"seen": {{"hash", false}, {"timesSeen", true}, {"path", false}},
+	"watch": {
+		{"watch", false}, {"type", false}, {"address", false}, {"hash", false}, {"bundle", false},
+	},
+}
+
+type wsClient struct {
+	conn       *websocket.Conn
+	remoteAddr string
+	topics     map[string]bool
+	jsonFormat bool
+	chOut      chan []byte
+	dropped    uint64
+}
+
+var (
+	wsClients      = make(map[*wsClient]struct{})
+	wsClientsMutex sync.RWMutex
+	wsUpgrader     = websocket.Upgrader{
+		// stream is public, same as Nanomsg output
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+func HandlerWSOutput(w http.ResponseWriter, r *http.Request) {
+	if !cfg.Get().IriMsgStream.OutputWSEnabled {
+		http.Error(w, "WebSocket output is disabled", http.StatusNotFound)
+		return
+	}
+	topics, err := parseWSTopics(r.URL.Query().Get("topics"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var jsonFormat bool
+	switch r.URL.Query().Get("format") {
+	case "", "raw":
+	case "json":
+		jsonFormat = true
+	default:
+		http.Error(w, "wrong 'format', expected 'raw' or 'json'", http.StatusBadRequest)
+		return
+	}
+	if numWSClients() >= cfg.Get().IriMsgStream.OutputWSMaxClients {
+		http.Error(w, "too many WebSocket clients", http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already responded to the client
+		debugf("WebSocket upgrade failed for %v: %v", r.RemoteAddr, err)
+		return
+	}
+	cl := &wsClient{
+		conn:       conn,
+		remoteAddr: r.RemoteAddr,
+		topics:     topics,
+		jsonFormat: jsonFormat,
+		chOut:      make(chan []byte, wsClientBufferSize),
+	}
+	addWSClient(cl)
+	go cl.writeLoop()
+	cl.readLoop()
+	removeWSClient(cl)
+}
+
+func parseWSTopics(param string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	if param == "" {
+		for _, t := range wsTopics {
+			ret[t] = true
+		}
+		return ret, nil
+	}
+	for _, t := range strings.Split(param, ",") {
+		t = strings.TrimSpace(t)
+		if _, ok := wsFields[t]; ok {
+			ret[t] = true
+			continue
+		}
+		f, err := parseOutFilter(t)
+		if err != nil {
+			return nil, fmt.Errorf("wrong topic '%v', expected one of %v or filter: %v", t, wsTopics, err)
+		}
+		ret[f.topic] = true
+	}
+	return ret, nil
+}
+
+func numWSClients() int {
+	wsClientsMutex.RLock()
+	defer wsClientsMutex.RUnlock()
+	return len(wsClients)
+}
+
+func addWSClient(cl *wsClient) {
+	wsClientsMutex.Lock()
+	defer wsClientsMutex.Unlock()
+	wsClients[cl] = struct{}{}
+	infof("WebSocket client %v connected. Json = %v. Total clients: %v", cl.remoteAddr, cl.jsonFormat, len(wsClients))
+}
+
+func removeWSClient(cl *wsClient) {
+	wsClientsMutex.Lock()
+	defer wsClientsMutex.Unlock()
+	if _, ok := wsClients[cl]; !ok {
+		return
+	}
+	delete(wsClients, cl)
+	// publishers send under read lock, so it is safe to close here
+	close(cl.chOut)
+	infof("WebSocket client %v disconnected. Dropped messages: %v. Total clients: %v",
+		cl.remoteAddr, atomic.LoadUint64(&cl.dropped), len(wsClients))
+}
+
+// client doesn't send anything. Reading only to detect closed connection and to process control frames
+func (cl *wsClient) readLoop() {
+	for {
+		if _, _, err := cl.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (cl *wsClient) writeLoop() {
+	defer cl.conn.Close()
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case msg, ok := <-cl.chOut:
+			_ = cl.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				_ = cl.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := cl.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				debugf("WebSocket client %v: %v", cl.remoteAddr, err)
+				return
+			}
+		case <-ping.C:
+			_ = cl.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := cl.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				debugf("WebSocket client %v: %v", cl.remoteAddr, err)
+				return
+			}
+		}
+	}
+}
+
+// sends message to all clients subscribed to the topic. Slow clients lose messages
+func publishWS(msgData []byte, msgSplit []string) {
+	wsClientsMutex.RLock()
+	defer wsClientsMutex.RUnlock()
+	if len(wsClients) == 0 || len(msgSplit) == 0 {
+		return
+	}
+	var jsonData []byte
+	var jsonErr error
+	for cl := range wsClients {
+		if !cl.topics[msgSplit[0]] {
+			continue
+		}
+		data := msgData
+		if cl.jsonFormat {
+			if jsonData == nil && jsonErr == nil {
+				if jsonData, jsonErr = msgToJSON(msgSplit); jsonErr != nil {
+					errorf("Can't convert '%v' message to json: %v", msgSplit[0], jsonErr)
+				}
+			}
+			if jsonErr != nil {
+				// only json clients miss the message
+				continue
+			}
+			data = jsonData
+		}
+		select {
+		case cl.chOut <- data:
+		default:
+			atomic.AddUint64(&cl.dropped, 1)
+		}
+	}
+}
+
+func msgToJSON(msgSplit []string) ([]byte, error) {
+	msgType := msgSplit[0]
+	if t, ok := derivedMsgType(msgType); ok {
+		msgType = t
+	}
+	fields, ok := wsFields[msgType]
+	if !ok {
+		return nil, fmt.Errorf("unknown topic")
+	}
+	if len(msgSplit) < len(fields)+1 {
+		return nil, fmt.Errorf("expected at least %v fields", len(fields)+1)
+	}
+	ret := make(map[string]interface{}, len(fields)+1)
+	ret["topic"] = msgSplit[0]
+	for i, f := range fields {
+		s := msgSplit[i+1]
+		if !f.isInt {
+			ret[f.name] = s
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected integer in field '%v'", f.name)
+		}
+		ret[f.name] = n
+	}
+	return json.Marshal(ret)
+}
@@ -0,0 +1,74 @@
+package inputpart
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-zeromq/zmq4"
+	"sync/atomic"
+	"time"
+)
+
+// output stream on ZMQ PUB socket, in parallel to Nanomsg. Messages are exactly the same as in Nanomsg output,
+// i.e. in IRI ZMQ format, so IRI ZMQ consumers can use Tanglebeat directly, without 'nano2zmq'.
+// Output is enabled and disabled together with Nanomsg output
+
+const zmqOutBufferSize = 1000
+
+type zmqPublisher struct {
+	sock    zmq4.Socket
+	url     string
+	chIn    chan []byte
+	dropped uint64
+}
+
+var zmqOutPublisher *zmqPublisher
+
+func initZMQOutput(port int) error {
+	if port == 0 {
+		infof("ZMQ output is DISABLED: 'outputZMQPort' is not set")
+		return nil
+	}
+	ret := &zmqPublisher{
+		sock: zmq4.NewPub(context.Background()),
+		url:  fmt.Sprintf("tcp://*:%v", port),
+		chIn: make(chan []byte, zmqOutBufferSize),
+	}
+	if err := ret.sock.Listen(ret.url); err != nil {
+		_ = ret.sock.Close()
+		return fmt.Errorf("can't listen ZMQ pub socket on %v: %v", ret.url, err)
+	}
+	zmqOutPublisher = ret
+	go ret.loop()
+	go ret.reportDroppedLoop()
+	infof("ZMQ output: PUB socket listening on %v", ret.url)
+	return nil
+}
+
+func (p *zmqPublisher) loop() {
+	for data := range p.chIn {
+		if err := p.sock.Send(zmq4.NewMsg(data)); err != nil {
+			errorf("ZMQ publisher of %v: %v", p.url, err)
+		}
+	}
+}
+
+func (p *zmqPublisher) reportDroppedLoop() {
+	for {
+		time.Sleep(1 * time.Minute)
+		if dropped := atomic.SwapUint64(&p.dropped, 0); dropped > 0 {
+			errorf("ZMQ publisher of %v: %v messages dropped in last minute because of full buffer", p.url, dropped)
+		}
+	}
+}
+
+// never blocks. Message is dropped if sending is behind
+func publishZMQ(msgData []byte) {
+	if zmqOutPublisher == nil || !compoundOutPublisher.IsEnabled() {
+		return
+	}
+	select {
+	case zmqOutPublisher.chIn <- msgData:
+	default:
+		atomic.AddUint64(&zmqOutPublisher.dropped, 1)
+	}
+}
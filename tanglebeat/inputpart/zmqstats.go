@@ -156,7 +156,7 @@ func getSeenOnceRate5to1Min(id byte) int {
 func updateZmqOutputSlowStats() {
 
 	// all retentionPeriod stats
-	retentionPeriodSec := uint64(cfg.Config.RetentionPeriodMin) * 60
+	retentionPeriodSec := uint64(cfg.Get().RetentionPeriodMin) * 60
 	var st ZmqOutputStatsStruct
 	txs := txcache.Stats(retentionPeriodSec*1000, GetTxQuorum())
 	st.TXCount = txs.TxCountPassed
@@ -8,12 +8,14 @@ import (
 
 // InputReader is abstract interface to the object with go routine which reads input from
 // ZeroMQ, Nanomsg or similar data sources
-// Upon i/o error routines stops running. Then starter routine restarts it again after some time
+// Upon i/o error routines stops running. Then starter routine restarts it again after some time.
+// Consecutive errors increase the delay exponentially, see 'restartDelay'
 
 type InputReader interface {
 	setRunning__()
-	setIdle__(time.Duration, ReasonNotRunning)
+	setIdle__(ReasonNotRunning) time.Duration
 	isTimeToRestart__() bool
+	getRestartAt__() time.Time
 
 	SetId__(byte)
 	GetId__() byte
@@ -30,7 +32,11 @@ type InputReader interface {
 	Run(string) ReasonNotRunning
 	GetReaderBaseStats__() *InputReaderBaseStats
 	IsOutputClosed() bool
-	SetOutputClosed(bool)
+	SetOutputClosed(bool, string)
+	IsDisabled__() bool
+	IsDisabled() bool
+	SetDisabled(bool)
+	Stop()
 	sync.Locker
 }
 
@@ -43,6 +49,7 @@ const (
 	REASON_NORUN_ONHOLD_15MIN ReasonNotRunning = "onHold15min"
 	REASON_NORUN_ONHOLD_30MIN ReasonNotRunning = "onHold30min"
 	REASON_NORUN_ONHOLD_1H    ReasonNotRunning = "onHold1h"
+	REASON_NORUN_DISABLED     ReasonNotRunning = "disabled"
 )
 
 type InputReaderBase struct {
@@ -50,11 +57,18 @@ type InputReaderBase struct {
 	running          bool
 	reading          bool
 	outputClosed     bool
+	valveReason      string
+	valveChangedAt   time.Time
+	disabled         bool
 	reasonNotRunning ReasonNotRunning
 	lastErr          string
 	restartAt        time.Time
 	ReadingSince     time.Time
 	lastHeartbeat    time.Time
+	// connection history
+	connectAttempts     uint64
+	lastSuccess         time.Time
+	consecutiveFailures int
 	sync.RWMutex
 }
 
@@ -64,6 +78,14 @@ type InputReaderBaseStats struct {
 	RunningSinceTs  uint64 `json:"runningSince"`
 	LastHeartbeatTs uint64 `json:"lastHeartbeat"`
 	OutputClosed    bool   `json:"outputClosed"`
+	ValveReason     string `json:"valveReason,omitempty"`
+	ValveChangedTs  uint64 `json:"valveChangedTs,omitempty"`
+	Disabled        bool   `json:"disabled"`
+	// connection history
+	ConnectAttempts     uint64 `json:"connectAttempts"`
+	LastSuccessTs       uint64 `json:"lastSuccessTs,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	RestartAtTs         uint64 `json:"restartAt,omitempty"`
 }
 
 func NewInputReaderBase() *InputReaderBase {
@@ -80,10 +102,40 @@ func (r *InputReaderBase) IsOutputClosed() bool {
 	return r.outputClosed
 }
 
-func (r *InputReaderBase) SetOutputClosed(closed bool) {
+// SetOutputClosed opens or closes output valve. Reason is the reason for closing
+func (r *InputReaderBase) SetOutputClosed(closed bool, reason string) {
 	r.Lock()
 	defer r.Unlock()
+	if r.outputClosed != closed {
+		r.valveChangedAt = time.Now()
+	}
 	r.outputClosed = closed
+	r.valveReason = reason
+}
+
+func (r *InputReaderBase) IsDisabled__() bool {
+	return r.disabled
+}
+
+func (r *InputReaderBase) IsDisabled() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.disabled
+}
+
+// disabled reader is not (re)started by the starter.
+// Enabling makes it eligible for restart immediately
+func (r *InputReaderBase) SetDisabled(disabled bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.disabled = disabled
+	if !disabled {
+		r.restartAt = time.Now()
+	}
+}
+
+// Stop does nothing by default. Readers which can be interrupted while running override it
+func (r *InputReaderBase) Stop() {
 }
 
 func (r *InputReaderBase) SetId__(id byte) {
@@ -100,6 +152,8 @@ func (r *InputReaderBase) SetReading(reading bool) {
 
 	if reading && !r.reading {
 		r.ReadingSince = time.Now()
+		// connected successfully. Backoff is reset only if connection lasts, see setIdle__
+		r.lastSuccess = r.ReadingSince
 	}
 	r.reading = reading
 }
@@ -132,12 +186,24 @@ func (r *InputReaderBase) isRunning__() bool {
 
 func (r *InputReaderBase) setRunning__() {
 	r.running = true
+	r.connectAttempts++
 }
 
-func (r *InputReaderBase) setIdle__(restartAfter time.Duration, reason ReasonNotRunning) {
+// returns delay before restart. Node which accepts connections and drops them soon after
+// is backed off as if it didn't connect at all
+func (r *InputReaderBase) setIdle__(reason ReasonNotRunning) time.Duration {
+	if r.reading && time.Since(r.ReadingSince) >= backoffResetUptime {
+		r.consecutiveFailures = 0
+	}
+	if reason == REASON_NORUN_ERROR {
+		r.consecutiveFailures++
+	}
+	restartAfter := restartDelay(reason, r.consecutiveFailures)
 	r.running = false
+	r.reading = false
 	r.reasonNotRunning = reason
 	r.restartAt = time.Now().Add(restartAfter)
+	return restartAfter
 }
 
 func (r *InputReaderBase) GetOnHoldInfo__() ReasonNotRunning {
@@ -145,15 +211,33 @@ func (r *InputReaderBase) GetOnHoldInfo__() ReasonNotRunning {
 }
 
 func (r *InputReaderBase) isTimeToRestart__() bool {
-	return time.Now().After(r.restartAt)
+	return !time.Now().Before(r.restartAt)
+}
+
+func (r *InputReaderBase) getRestartAt__() time.Time {
+	return r.restartAt
 }
 
 func (r *InputReaderBase) GetReaderBaseStats__() *InputReaderBaseStats {
-	return &InputReaderBaseStats{
-		Running:         r.running && r.reading,
-		LastErr:         r.lastErr,
-		RunningSinceTs:  utils.UnixMs(r.ReadingSince),
-		LastHeartbeatTs: utils.UnixMs(r.lastHeartbeat),
-		OutputClosed:    r.outputClosed,
+	ret := &InputReaderBaseStats{
+		Running:             r.running && r.reading,
+		LastErr:             r.lastErr,
+		RunningSinceTs:      utils.UnixMs(r.ReadingSince),
+		LastHeartbeatTs:     utils.UnixMs(r.lastHeartbeat),
+		OutputClosed:        r.outputClosed,
+		ValveReason:         r.valveReason,
+		Disabled:            r.disabled,
+		ConnectAttempts:     r.connectAttempts,
+		ConsecutiveFailures: r.consecutiveFailures,
+	}
+	if !r.valveChangedAt.IsZero() {
+		ret.ValveChangedTs = utils.UnixMs(r.valveChangedAt)
+	}
+	if !r.lastSuccess.IsZero() {
+		ret.LastSuccessTs = utils.UnixMs(r.lastSuccess)
+	}
+	if !r.running && !r.disabled {
+		ret.RestartAtTs = utils.UnixMs(r.restartAt)
 	}
+	return ret
 }
@@ -28,7 +28,7 @@ func debugf(format string, args ...interface{}) {
 	if !localDebug {
 		return
 	}
-	if !cfg.Config.Debug {
+	if !cfg.Get().Debug {
 		return
 	}
 	if localLog != nil {
@@ -1,20 +1,48 @@
 package inreaders
 
 import (
+	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 )
 
+const (
+	// starter checks readers at least that often, even if nothing happens
+	starterMaxSleep = 1 * time.Minute
+	// connection must last that long to reset backoff of the reader
+	backoffResetUptime = 1 * time.Minute
+)
+
+// backoff of restarts after errors, see SetReconnectParams
+var (
+	reconnectMinDelay = 15 * time.Second
+	reconnectMaxDelay = 10 * time.Minute
+	reconnectJitter   = 0.2
+	reconnectMutex    sync.RWMutex
+)
+
 type InputReaderSet struct {
 	sync.RWMutex
-	name   string // for logging
-	theSet map[string]InputReader
+	name         string // for logging
+	theSet       map[string]InputReader
+	chWakeup     chan struct{}
+	nextId       int          // next never used id
+	released     []releasedId // ids of removed readers, oldest first
+	idQuarantine time.Duration
+	restoredIds  map[string]byte // ids of readers before restart, see RestoreIds
+}
+
+type releasedId struct {
+	id         byte
+	releasedAt time.Time
 }
 
 func NewInputReaderSet(name string) *InputReaderSet {
 	ret := &InputReaderSet{
-		name:   name,
-		theSet: make(map[string]InputReader),
+		name:     name,
+		theSet:   make(map[string]InputReader),
+		chWakeup: make(chan struct{}, 1),
 	}
 	go ret.runStarter()
 	return ret
@@ -34,58 +62,219 @@ func (irs *InputReaderSet) NumRunning() int {
 	return ret
 }
 
-func (irs *InputReaderSet) AddInputReader(name string, ir InputReader) {
+func (irs *InputReaderSet) AddInputReader(name string, ir InputReader) bool {
+	irs.Lock()
+	defer irs.Unlock()
+	if _, ok := irs.theSet[name]; ok {
+		return false
+	}
+	id, ok := irs.freeId__(name)
+	if !ok {
+		errorf("Routine set '%v': can't add routine '%v': no free ids left. Ids of removed routines are reused after %v",
+			irs.name, name, irs.idQuarantine)
+		return false
+	}
+	ir.SetId__(id)
+	irs.theSet[name] = ir
+	debugf("Routine set '%v': added routine '%v' with id %v", irs.name, name, id)
+	irs.wakeup()
+	return true
+}
+
+// SetIdQuarantine sets how long id of the removed reader is not given to new readers.
+// Caches keep ids of the sources, so the id must not be reused before cache entries with it expire
+func (irs *InputReaderSet) SetIdQuarantine(d time.Duration) {
+	irs.Lock()
+	defer irs.Unlock()
+	irs.idQuarantine = d
+}
+
+// ids are bytes, allocated in increasing order. When all 256 were used, ids of removed readers
+// are reused, the one released earliest first, but not before quarantine period has passed.
+// Reader which had an id before restart gets the same id
+func (irs *InputReaderSet) freeId__(name string) (byte, bool) {
+	if id, ok := irs.restoredIds[name]; ok {
+		delete(irs.restoredIds, name)
+		irs.unrelease__(id)
+		return id, true
+	}
+	if irs.nextId < 256 {
+		irs.nextId++
+		return byte(irs.nextId - 1), true
+	}
+	if len(irs.released) == 0 || time.Since(irs.released[0].releasedAt) < irs.idQuarantine {
+		return 0, false
+	}
+	ret := irs.released[0].id
+	irs.released = irs.released[1:]
+	for n, id := range irs.restoredIds {
+		if id == ret {
+			delete(irs.restoredIds, n)
+		}
+	}
+	return ret, true
+}
+
+func (irs *InputReaderSet) unrelease__(id byte) {
+	for i := range irs.released {
+		if irs.released[i].id == id {
+			irs.released = append(irs.released[:i], irs.released[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetIds returns ids of readers, including ids restored and not taken yet, and the next never used id.
+// Saved with the snapshot of caches which keep ids of sources
+func (irs *InputReaderSet) GetIds() (map[string]byte, int) {
+	irs.RLock()
+	defer irs.RUnlock()
+	ret := make(map[string]byte, len(irs.theSet)+len(irs.restoredIds))
+	for name, id := range irs.restoredIds {
+		ret[name] = id
+	}
+	for name, r := range irs.theSet {
+		ret[name] = r.GetId__()
+	}
+	return ret, irs.nextId
+}
+
+// RestoreIds is called before readers are added, when caches are restored from the snapshot.
+// Readers will get the same ids as they had when the snapshot was saved.
+// All ids used before are treated as released at 'savedAt' until taken by the reader again
+func (irs *InputReaderSet) RestoreIds(ids map[string]byte, nextId int, savedAt time.Time) {
+	irs.Lock()
+	defer irs.Unlock()
+	irs.restoredIds = make(map[string]byte, len(ids))
+	for name, id := range ids {
+		irs.restoredIds[name] = id
+	}
+	if nextId > 256 {
+		nextId = 256
+	}
+	irs.nextId = nextId
+	irs.released = make([]releasedId, 0, nextId)
+	for id := 0; id < nextId; id++ {
+		irs.released = append(irs.released, releasedId{id: byte(id), releasedAt: savedAt})
+	}
+}
+
+// RemoveInputReader stops the reader (if running) and removes it from the set
+func (irs *InputReaderSet) RemoveInputReader(name string) error {
 	irs.Lock()
 	defer irs.Unlock()
-	_, ok := irs.theSet[name]
+	ir, ok := irs.theSet[name]
 	if !ok {
-		ir.SetId__(byte(len(irs.theSet)))
-		irs.theSet[name] = ir
-		debugf("Routine set '%v': added routine '%v'", irs.name, name)
+		return fmt.Errorf("input reader '%v' not found", name)
 	}
+	delete(irs.theSet, name)
+	irs.released = append(irs.released, releasedId{id: ir.GetId__(), releasedAt: time.Now()})
+	ir.SetDisabled(true)
+	ir.Stop()
+	debugf("Routine set '%v': removed routine '%v'", irs.name, name)
+	return nil
 }
 
+// DisableInputReader stops the reader and keeps it in the set. It won't be restarted until enabled
+func (irs *InputReaderSet) DisableInputReader(name string) error {
+	irs.Lock()
+	defer irs.Unlock()
+	ir, ok := irs.theSet[name]
+	if !ok {
+		return fmt.Errorf("input reader '%v' not found", name)
+	}
+	ir.SetDisabled(true)
+	ir.Stop()
+	debugf("Routine set '%v': disabled routine '%v'", irs.name, name)
+	return nil
+}
+
+func (irs *InputReaderSet) EnableInputReader(name string) error {
+	irs.Lock()
+	defer irs.Unlock()
+	ir, ok := irs.theSet[name]
+	if !ok {
+		return fmt.Errorf("input reader '%v' not found", name)
+	}
+	ir.SetDisabled(false)
+	debugf("Routine set '%v': enabled routine '%v'", irs.name, name)
+	irs.wakeup()
+	return nil
+}
+
+func (irs *InputReaderSet) Get(name string) (InputReader, bool) {
+	irs.RLock()
+	defer irs.RUnlock()
+	ret, ok := irs.theSet[name]
+	return ret, ok
+}
+
+// starter (re)starts readers when it is time. It sleeps until the earliest restart time
+// or until woken up by a change in the set
 func (irs *InputReaderSet) runStarter() {
 	debugf("---- running starter '%v'", irs.name)
+	timer := time.NewTimer(0)
 	for {
-		irs.Lock()
-		for n, r := range irs.theSet {
-			inputRoutine := r
-			name := n
-			//----------------
-			inputRoutine.Lock()
-			if !inputRoutine.isRunning__() && inputRoutine.isTimeToRestart__() {
+		sleep := time.Until(irs.startReaders())
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(sleep)
+		select {
+		case <-irs.chWakeup:
+		case <-timer.C:
+		}
+	}
+}
+
+func (irs *InputReaderSet) wakeup() {
+	select {
+	case irs.chWakeup <- struct{}{}:
+	default:
+		// already signalled
+	}
+}
+
+// starts readers which are due and returns time of the next restart
+func (irs *InputReaderSet) startReaders() time.Time {
+	irs.Lock()
+	defer irs.Unlock()
+
+	nextRestart := time.Now().Add(starterMaxSleep)
+	for n, r := range irs.theSet {
+		inputRoutine := r
+		name := n
+		//----------------
+		inputRoutine.Lock()
+		if !inputRoutine.isRunning__() && !inputRoutine.IsDisabled__() {
+			if inputRoutine.isTimeToRestart__() {
 				inputRoutine.setRunning__()
 				debugf("Time to run input routine %v. Go run!", name)
-				go func() {
-					stopReason := inputRoutine.Run(name)
-					var restartAfter time.Duration
-					switch stopReason {
-					case REASON_NORUN_ONHOLD_10MIN:
-						restartAfter = 10 * time.Minute
-					case REASON_NORUN_ONHOLD_15MIN:
-						restartAfter = 15 * time.Minute
-					case REASON_NORUN_ONHOLD_30MIN:
-						restartAfter = 30 * time.Minute
-					case REASON_NORUN_ONHOLD_1H:
-						restartAfter = 1 * time.Hour
-					case REASON_NORUN_ERROR:
-						restartAfter = 15 * time.Second
-					default:
-						restartAfter = 1 * time.Minute
-					}
-					inputRoutine.Lock()
-					inputRoutine.setIdle__(restartAfter, stopReason)
-					inputRoutine.Unlock()
-					debugf("Stopped input routine '%v'. Will be restarted after %v", name, restartAfter)
-				}()
+				go irs.runReader(name, inputRoutine)
+			} else if inputRoutine.getRestartAt__().Before(nextRestart) {
+				nextRestart = inputRoutine.getRestartAt__()
 			}
-			inputRoutine.Unlock()
-			//---------------
 		}
-		irs.Unlock()
-		time.Sleep(10 * time.Second)
+		inputRoutine.Unlock()
+		//---------------
 	}
+	return nextRestart
+}
+
+func (irs *InputReaderSet) runReader(name string, inputRoutine InputReader) {
+	stopReason := inputRoutine.Run(name)
+	inputRoutine.Lock()
+	restartAfter := inputRoutine.setIdle__(stopReason)
+	inputRoutine.Unlock()
+	if stopReason == REASON_NORUN_DISABLED {
+		debugf("Stopped input routine '%v': disabled", name)
+	} else {
+		debugf("Stopped input routine '%v'. Will be restarted after %v", name, restartAfter)
+	}
+	irs.wakeup()
 }
 
 func (irs *InputReaderSet) ForEach(callback func(name string, ir InputReader)) {
@@ -95,3 +284,57 @@ func (irs *InputReaderSet) ForEach(callback func(name string, ir InputReader)) {
 		callback(name, ir)
 	}
 }
+
+// SetReconnectParams sets delays of restarts after errors: from minDelay, doubling with each consecutive failure
+// up to maxDelay. Delays are randomized by +-jitter, 0 to 1. Called at start and when config is reloaded
+func SetReconnectParams(minDelay, maxDelay time.Duration, jitter float64) {
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	if jitter < 0 {
+		jitter = 0
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	reconnectMutex.Lock()
+	defer reconnectMutex.Unlock()
+	reconnectMinDelay = minDelay
+	reconnectMaxDelay = maxDelay
+	reconnectJitter = jitter
+}
+
+// delay before restart of the reader. Errors are retried with exponential backoff up to the cap,
+// on hold readers are restarted after fixed time. Jitter spreads restarts of many readers in time
+func restartDelay(reason ReasonNotRunning, consecutiveFailures int) time.Duration {
+	reconnectMutex.RLock()
+	minDelay, maxDelay, jitterMax := reconnectMinDelay, reconnectMaxDelay, reconnectJitter
+	reconnectMutex.RUnlock()
+
+	var ret time.Duration
+	switch reason {
+	case REASON_NORUN_ONHOLD_10MIN:
+		ret = 10 * time.Minute
+	case REASON_NORUN_ONHOLD_15MIN:
+		ret = 15 * time.Minute
+	case REASON_NORUN_ONHOLD_30MIN:
+		ret = 30 * time.Minute
+	case REASON_NORUN_ONHOLD_1H:
+		ret = 1 * time.Hour
+	case REASON_NORUN_ERROR:
+		ret = minDelay
+		for i := 1; i < consecutiveFailures && ret < maxDelay; i++ {
+			ret *= 2
+		}
+		if ret > maxDelay {
+			ret = maxDelay
+		}
+	case REASON_NORUN_DISABLED:
+		return 0
+	default:
+		ret = 1 * time.Minute
+	}
+	// +- jitter
+	jitter := jitterMax * (2*rand.Float64() - 1)
+	return time.Duration(float64(ret) * (1 + jitter))
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"github.com/unioproject/tanglebeat/lib/ebuffer"
 	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/ha"
 	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
 	"github.com/unioproject/tanglebeat/tanglebeat/inreaders"
 	"github.com/unioproject/tanglebeat/tanglebeat/senderpart"
@@ -11,6 +12,9 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // TODO clean unnecessary metrics
@@ -23,33 +27,60 @@ func main() {
 
 	cfg.MustReadConfig(*pcfgfile)
 	setLogs()
+	setReconnectParams(cfg.Config.Reconnect)
+	loadInputsOverlay()
+	inputsZMQ, inputsNanomsg := getEffectiveInputs(&cfg.Config)
+	if err := checkConfigQuorums(&cfg.Config); err != nil {
+		errorf("Wrong config: %v", err)
+		os.Exit(1)
+	}
+	// before outputs are started
+	ha.Start(cfg.Config.Federation.InstanceId, cfg.Config.HA.LeaseFile, time.Duration(cfg.Config.HA.LeaseSec)*time.Second)
+
 	inputpart.MustInitInputRoutines(
 		cfg.Config.IriMsgStream.OutputEnabled,
 		cfg.Config.IriMsgStream.OutputPort,
-		cfg.Config.IriMsgStream.InputsZMQ,
-		cfg.Config.IriMsgStream.InputsNanomsg)
+		cfg.Config.IriMsgStream.OutputTLS,
+		cfg.Config.IriMsgStream.OutputZMQPort,
+		inputsZMQ,
+		inputsNanomsg,
+		cfg.Config.IriMsgStream.InputsReplay)
+	pauseInputsFromOverlay()
 
 	senderpart.MustInitSenderDataCollector(
 		cfg.Config.SenderMsgStream.OutputEnabled,
 		cfg.Config.SenderMsgStream.OutputPort,
+		cfg.Config.SenderMsgStream.OutputTLS,
 		cfg.Config.SenderMsgStream.InputsNanomsg)
 
 	initGlobStatsCollector(5)
+	startDiscovery()
+	startHealthPruning()
 	spawnCommands()
 
 	chInterrupt := make(chan os.Signal, 2)
-	signal.Notify(chInterrupt)
+	signal.Notify(chInterrupt, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
 	go func() {
-		<-chInterrupt
-		warningf("Exiting after interrupt")
-		cleanup()
-		os.Exit(0)
+		for sig := range chInterrupt {
+			if sig == syscall.SIGHUP {
+				reloadConfig(*pcfgfile)
+				continue
+			}
+			warningf("Exiting after interrupt")
+			cleanup()
+			os.Exit(0)
+		}
 	}()
 	runWebServer(cfg.Config.WebServerPort)
 }
 
 func cleanup() {
+	ha.Release()
 	killCommands()
+	inputpart.StopRecorder()
+	if err := inputpart.SaveCacheSnapshot(); err != nil {
+		errorf("%v", err)
+	}
 }
 
 func setLogs() {
@@ -57,14 +88,23 @@ func setLogs() {
 	inreaders.SetLog(cfg.GetLog(), true)
 	inputpart.SetLog(cfg.GetLog(), false)
 	senderpart.SetLog(cfg.GetLog(), false)
+	ha.SetLog(cfg.GetLog(), false)
 	ebuffer.SetLog(cfg.GetLog(), false)
 }
 
+func setReconnectParams(r cfg.ReconnectYAML) {
+	inreaders.SetReconnectParams(
+		time.Duration(r.MinDelaySec)*time.Second, time.Duration(r.MaxDelaySec)*time.Second, *r.Jitter)
+}
+
 // spawning cmd lines specified in spawnCmd part of the config file
 // each command is started in the separate go routine and stdout and stderr are redirected to
 // the current output
 
-var runningCmd = make([]*exec.Cmd, 0)
+var (
+	runningCmd      = make(map[string]*exec.Cmd)
+	runningCmdMutex sync.Mutex
+)
 
 func spawnCommands() {
 	for _, cmd := range cfg.Config.SpawnCmd {
@@ -73,6 +113,13 @@ func spawnCommands() {
 }
 
 func spawnCmd(cmdline string) {
+	runningCmdMutex.Lock()
+	defer runningCmdMutex.Unlock()
+
+	if _, ok := runningCmd[cmdline]; ok {
+		errorf("Command '%v' is already running", cmdline)
+		return
+	}
 	infof("Spawning command '%v' from 'tanglebeat'", cmdline)
 
 	words := strings.Split(cmdline, " ")
@@ -86,11 +133,30 @@ func spawnCmd(cmdline string) {
 	if err := cmd.Start(); err != nil {
 		errorf("Failed to run '%v' from 'tanglebeat': %v", cmdline, err)
 	} else {
-		runningCmd = append(runningCmd, cmd)
+		runningCmd[cmdline] = cmd
 	}
 }
 
+func killCmd(cmdline string) {
+	runningCmdMutex.Lock()
+	defer runningCmdMutex.Unlock()
+
+	cmd, ok := runningCmd[cmdline]
+	if !ok {
+		return
+	}
+	infof("Killing command %v %v", cmd.Path, cmd.Args)
+	_ = cmd.Process.Kill()
+	go func() {
+		_ = cmd.Wait()
+	}()
+	delete(runningCmd, cmdline)
+}
+
 func killCommands() {
+	runningCmdMutex.Lock()
+	defer runningCmdMutex.Unlock()
+
 	for _, cmd := range runningCmd {
 		infof("Killing command %v %v", cmd.Path, cmd.Args)
 		_ = cmd.Process.Kill()
@@ -0,0 +1,96 @@
+package main
+
+import (
+	. "github.com/prometheus/client_golang/prometheus"
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
+)
+
+// per input metrics, labeled by input uri.
+// If 'inputMetricsMaskIP' is set, uri with IP address is masked, see inputpart.MetricsUriLabel
+
+var (
+	inputTpsGauge          *GaugeVec
+	inputCtpsGauge         *GaugeVec
+	inputConfRateGauge     *GaugeVec
+	inputLastLmiGauge      *GaugeVec
+	inputLmiLagGauge       *GaugeVec
+	inputLmiStaleGauge     *GaugeVec
+	inputSeenOnceRateGauge *GaugeVec
+	inputObsoleteSnGauge   *GaugeVec
+	inputLatencyGauge      *GaugeVec
+	inputHeartbeatAgeGauge *GaugeVec
+	inputStateGauge        *GaugeVec
+
+	// label values of the previous update, to delete series of removed inputs
+	inputMetricsLabels = make(map[string]string) // uri label -> state
+)
+
+func init() {
+	newInputGauge := func(name, help string, labels ...string) *GaugeVec {
+		ret := NewGaugeVec(GaugeOpts{
+			Name: name,
+			Help: help,
+		}, append([]string{"uri"}, labels...))
+		MustRegister(ret)
+		return ret
+	}
+	inputTpsGauge = newInputGauge("tanglebeat_input_tps", "TPS of the input")
+	inputCtpsGauge = newInputGauge("tanglebeat_input_ctps", "CTPS of the input")
+	inputConfRateGauge = newInputGauge("tanglebeat_input_conf_rate", "Confirmation rate of the input, %")
+	inputLastLmiGauge = newInputGauge("tanglebeat_input_last_lmi", "Last milestone index received from the input")
+	inputLmiLagGauge = newInputGauge("tanglebeat_input_lmi_lag",
+		"Number of milestones the input is behind the quorum milestone index")
+	inputLmiStaleGauge = newInputGauge("tanglebeat_input_lmi_stale_sec",
+		"Seconds since milestone index of the input last advanced")
+	inputSeenOnceRateGauge = newInputGauge("tanglebeat_input_seen_once_rate",
+		"Percentage of transactions seen only from this input")
+	inputObsoleteSnGauge = newInputGauge("tanglebeat_input_obsolete_sn_count",
+		"Number of SN messages from the input with obsolete milestone index")
+	inputLatencyGauge = newInputGauge("tanglebeat_input_latency_sec", "Average latency of the input")
+	inputHeartbeatAgeGauge = newInputGauge("tanglebeat_input_heartbeat_age_sec",
+		"Seconds since last message from the input")
+	inputStateGauge = newInputGauge("tanglebeat_input_state",
+		"1 for the current state of the input", "state")
+}
+
+func inputMetricsUri(inp *inputpart.ZmqRoutineStats) string {
+	return inputpart.MetricsUriLabel(inp.Uri)
+}
+
+func updateInputMetrics(inputs []*inputpart.ZmqRoutineStats) {
+	current := make(map[string]string, len(inputs))
+	for _, inp := range inputs {
+		uri := inputMetricsUri(inp)
+		current[uri] = inp.State
+		l := Labels{"uri": uri}
+		inputTpsGauge.With(l).Set(inp.Tps)
+		inputCtpsGauge.With(l).Set(inp.Ctps)
+		inputConfRateGauge.With(l).Set(float64(inp.Confrate))
+		inputLastLmiGauge.With(l).Set(float64(inp.LastLmi))
+		inputLmiLagGauge.With(l).Set(float64(inp.LmiLag))
+		inputLmiStaleGauge.With(l).Set(float64(inp.LmiStaleSec))
+		inputSeenOnceRateGauge.With(l).Set(float64(inp.SeenOnceRate))
+		inputObsoleteSnGauge.With(l).Set(float64(inp.ObsoleteConfirmCount))
+		inputLatencyGauge.With(l).Set(inp.AvgLatencySec)
+		inputHeartbeatAgeGauge.With(l).Set(float64(utils.SinceUnixMs(inp.LastHeartbeatTs)) / 1000)
+
+		if prevState, ok := inputMetricsLabels[uri]; ok && prevState != inp.State {
+			inputStateGauge.Delete(Labels{"uri": uri, "state": prevState})
+		}
+		inputStateGauge.With(Labels{"uri": uri, "state": inp.State}).Set(1)
+	}
+	for uri, state := range inputMetricsLabels {
+		if _, ok := current[uri]; ok {
+			continue
+		}
+		l := Labels{"uri": uri}
+		for _, g := range []*GaugeVec{inputTpsGauge, inputCtpsGauge, inputConfRateGauge, inputLastLmiGauge,
+			inputLmiLagGauge, inputLmiStaleGauge, inputSeenOnceRateGauge, inputObsoleteSnGauge,
+			inputLatencyGauge, inputHeartbeatAgeGauge} {
+			g.Delete(l)
+		}
+		inputStateGauge.Delete(Labels{"uri": uri, "state": state})
+	}
+	inputMetricsLabels = current
+}
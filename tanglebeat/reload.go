@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
+	"reflect"
+)
+
+// reloading of the config file upon SIGHUP.
+// New config is compared with the running one and changes are applied live.
+// If any of changes can't be applied without restart, the whole reload is refused
+
+func reloadConfig(cfgfile string) {
+	infof("Config reload: reading '%v'", cfgfile)
+	newCfg, err := cfg.ReadConfig(cfgfile)
+	if err != nil {
+		errorf("Config reload refused: %v", err)
+		return
+	}
+	if err = checkConfigQuorums(newCfg); err != nil {
+		errorf("Config reload refused: %v", err)
+		return
+	}
+	if unsafe := getUnsafeChanges(cfg.Get(), newCfg); len(unsafe) != 0 {
+		for _, msg := range unsafe {
+			errorf("Config reload refused: %v", msg)
+		}
+		return
+	}
+	if numChanges := applyConfigChanges(newCfg); numChanges == 0 {
+		infof("Config reload: no changes")
+	} else {
+		infof("Config reload: %d change(s) applied", numChanges)
+	}
+}
+
+// changes which require restart
+func getUnsafeChanges(oldCfg, newCfg *cfg.ConfigStructYAML) []string {
+	ret := make([]string, 0)
+	if oldCfg.WebServerPort != newCfg.WebServerPort {
+		ret = append(ret, fmt.Sprintf("can't change 'webServerPort' %v -> %v without restart",
+			oldCfg.WebServerPort, newCfg.WebServerPort))
+	}
+	if oldCfg.IriMsgStream.OutputPort != newCfg.IriMsgStream.OutputPort {
+		ret = append(ret, fmt.Sprintf("can't change 'iriMsgStream.outputPort' %v -> %v without restart",
+			oldCfg.IriMsgStream.OutputPort, newCfg.IriMsgStream.OutputPort))
+	}
+	if oldCfg.IriMsgStream.OutputZMQPort != newCfg.IriMsgStream.OutputZMQPort {
+		ret = append(ret, fmt.Sprintf("can't change 'iriMsgStream.outputZMQPort' %v -> %v without restart",
+			oldCfg.IriMsgStream.OutputZMQPort, newCfg.IriMsgStream.OutputZMQPort))
+	}
+	if oldCfg.IriMsgStream.OutputTLS != newCfg.IriMsgStream.OutputTLS {
+		ret = append(ret, fmt.Sprintf("can't change 'iriMsgStream.outputTLS' %v -> %v without restart",
+			oldCfg.IriMsgStream.OutputTLS, newCfg.IriMsgStream.OutputTLS))
+	}
+	if oldCfg.Federation.InstanceId != newCfg.Federation.InstanceId {
+		ret = append(ret, fmt.Sprintf("can't change 'federation.instanceId' '%v' -> '%v' without restart",
+			oldCfg.Federation.InstanceId, newCfg.Federation.InstanceId))
+	}
+	if !reflect.DeepEqual(oldCfg.Federation.Upstreams, newCfg.Federation.Upstreams) {
+		ret = append(ret, "can't change 'federation.upstreams' without restart")
+	}
+	if oldCfg.HA != newCfg.HA {
+		ret = append(ret, "can't change 'ha' without restart")
+	}
+	if !reflect.DeepEqual(oldCfg.TLS, newCfg.TLS) {
+		ret = append(ret, "can't change 'tls' without restart")
+	}
+	if !reflect.DeepEqual(oldCfg.IriMsgStream.InputsReplay, newCfg.IriMsgStream.InputsReplay) {
+		ret = append(ret, "can't change 'iriMsgStream.inputsReplay' without restart")
+	}
+	if oldCfg.RecordDir != newCfg.RecordDir || oldCfg.RecordRotateMin != newCfg.RecordRotateMin ||
+		oldCfg.RecordMaxFiles != newCfg.RecordMaxFiles {
+		ret = append(ret, "can't change parameters of the recorder without restart")
+	}
+	if !reflect.DeepEqual(oldCfg.SenderMsgStream, newCfg.SenderMsgStream) {
+		ret = append(ret, "can't change 'senderMsgStream' without restart")
+	}
+	if oldCfg.InputsOverlayFile != newCfg.InputsOverlayFile {
+		ret = append(ret, fmt.Sprintf("can't change 'inputsOverlayFile' '%v' -> '%v' without restart",
+			oldCfg.InputsOverlayFile, newCfg.InputsOverlayFile))
+	}
+	return ret
+}
+
+func logChange(name string, oldValue, newValue interface{}) {
+	infof("Config reload: '%v' changed %v -> %v", name, oldValue, newValue)
+}
+
+// returns number of changes. Changes are made in the copy of the running config, which then replaces it.
+// Running config is read concurrently and is never modified in place. Called only from the SIGHUP routine
+func applyConfigChanges(newCfg *cfg.ConfigStructYAML) int {
+	var ret int
+	oldCfg := cfg.Get()
+	c := new(cfg.ConfigStructYAML)
+	*c = *oldCfg
+
+	if c.Debug != newCfg.Debug {
+		logChange("debug", c.Debug, newCfg.Debug)
+		c.Debug = newCfg.Debug
+		ret++
+	}
+	if c.QuorumTxToPass != newCfg.QuorumTxToPass {
+		logChange("quorumToPass", c.QuorumTxToPass, newCfg.QuorumTxToPass)
+		c.QuorumTxToPass = newCfg.QuorumTxToPass
+		ret++
+	}
+	if c.QuorumSnToPass != newCfg.QuorumSnToPass {
+		logChange("quorumSnToPass", c.QuorumSnToPass, newCfg.QuorumSnToPass)
+		c.QuorumSnToPass = newCfg.QuorumSnToPass
+		ret++
+	}
+	if c.QuorumLmiToPass != newCfg.QuorumLmiToPass {
+		logChange("quorumLmiToPass", c.QuorumLmiToPass, newCfg.QuorumLmiToPass)
+		c.QuorumLmiToPass = newCfg.QuorumLmiToPass
+		ret++
+	}
+	if c.AdaptiveQuorum != newCfg.AdaptiveQuorum {
+		logChange("adaptiveQuorum", c.AdaptiveQuorum, newCfg.AdaptiveQuorum)
+		c.AdaptiveQuorum = newCfg.AdaptiveQuorum
+		ret++
+	}
+	if c.WeightedQuorum != newCfg.WeightedQuorum {
+		logChange("weightedQuorum", c.WeightedQuorum, newCfg.WeightedQuorum)
+		c.WeightedQuorum = newCfg.WeightedQuorum
+		ret++
+	}
+	if c.AutoWeights != newCfg.AutoWeights {
+		logChange("autoWeights", c.AutoWeights, newCfg.AutoWeights)
+		c.AutoWeights = newCfg.AutoWeights
+		ret++
+	}
+	if !reflect.DeepEqual(c.InputWeights, newCfg.InputWeights) {
+		logChange("inputWeights", c.InputWeights, newCfg.InputWeights)
+		c.InputWeights = newCfg.InputWeights
+		ret++
+	}
+	if c.QuorumMilestoneHashToPass != newCfg.QuorumMilestoneHashToPass {
+		logChange("quorumMilestoneHashToPass", c.QuorumMilestoneHashToPass, newCfg.QuorumMilestoneHashToPass)
+		c.QuorumMilestoneHashToPass = newCfg.QuorumMilestoneHashToPass
+		ret++
+	}
+	if c.TimeIntervalMilestoneHashToPassMsec != newCfg.TimeIntervalMilestoneHashToPassMsec {
+		logChange("timeIntervalMilestoneHashToPassMsec",
+			c.TimeIntervalMilestoneHashToPassMsec, newCfg.TimeIntervalMilestoneHashToPassMsec)
+		c.TimeIntervalMilestoneHashToPassMsec = newCfg.TimeIntervalMilestoneHashToPassMsec
+		ret++
+	}
+	if c.MultiQuorumMetricsEnabled != newCfg.MultiQuorumMetricsEnabled {
+		logChange("multiQuorumMetricsEnabled", c.MultiQuorumMetricsEnabled, newCfg.MultiQuorumMetricsEnabled)
+		c.MultiQuorumMetricsEnabled = newCfg.MultiQuorumMetricsEnabled
+		ret++
+	}
+	if c.QuorumUpdatesEnabled != newCfg.QuorumUpdatesEnabled {
+		logChange("quorumUpdatesEnabled", c.QuorumUpdatesEnabled, newCfg.QuorumUpdatesEnabled)
+		c.QuorumUpdatesEnabled = newCfg.QuorumUpdatesEnabled
+		ret++
+	}
+	if c.QuorumUpdatesFrom != newCfg.QuorumUpdatesFrom {
+		logChange("quorumUpdatesFrom", c.QuorumUpdatesFrom, newCfg.QuorumUpdatesFrom)
+		c.QuorumUpdatesFrom = newCfg.QuorumUpdatesFrom
+		ret++
+	}
+	if c.QuorumUpdatesTo != newCfg.QuorumUpdatesTo {
+		logChange("quorumUpdatesTo", c.QuorumUpdatesTo, newCfg.QuorumUpdatesTo)
+		c.QuorumUpdatesTo = newCfg.QuorumUpdatesTo
+		ret++
+	}
+	if c.AdminToken != newCfg.AdminToken {
+		logChange("adminToken", "(hidden)", "(hidden)")
+		c.AdminToken = newCfg.AdminToken
+		ret++
+	}
+	if c.ReplaySpeed != newCfg.ReplaySpeed {
+		logChange("replaySpeed", c.ReplaySpeed, newCfg.ReplaySpeed)
+		c.ReplaySpeed = newCfg.ReplaySpeed
+		ret++
+	}
+	if c.TransfersFeedSize != newCfg.TransfersFeedSize {
+		logChange("transfersFeedSize", c.TransfersFeedSize, newCfg.TransfersFeedSize)
+		c.TransfersFeedSize = newCfg.TransfersFeedSize
+		ret++
+	}
+	if !reflect.DeepEqual(c.WatchList, newCfg.WatchList) {
+		logChange("watchList", len(c.WatchList), len(newCfg.WatchList))
+		c.WatchList = newCfg.WatchList
+		inputpart.SetConfigWatchList(newCfg.WatchList)
+		ret++
+	}
+	if !reflect.DeepEqual(c.OutputFilters, newCfg.OutputFilters) {
+		logChange("outputFilters", c.OutputFilters, newCfg.OutputFilters)
+		c.OutputFilters = newCfg.OutputFilters
+		inputpart.SetConfigOutFilters(newCfg.OutputFilters)
+		ret++
+	}
+	if c.InputMetricsMaskIP != newCfg.InputMetricsMaskIP {
+		logChange("inputMetricsMaskIP", c.InputMetricsMaskIP, newCfg.InputMetricsMaskIP)
+		c.InputMetricsMaskIP = newCfg.InputMetricsMaskIP
+		ret++
+	}
+	if c.SyncLag != newCfg.SyncLag {
+		logChange("syncLag", c.SyncLag, newCfg.SyncLag)
+		c.SyncLag = newCfg.SyncLag
+		ret++
+	}
+	if !reflect.DeepEqual(c.OutputValve, newCfg.OutputValve) {
+		logChange("outputValve", c.OutputValve, newCfg.OutputValve)
+		c.OutputValve = newCfg.OutputValve
+		ret++
+	}
+	if c.Health != newCfg.Health {
+		logChange("health", c.Health, newCfg.Health)
+		c.Health = newCfg.Health
+		ret++
+	}
+	if !reflect.DeepEqual(c.Discovery, newCfg.Discovery) {
+		logChange("discovery", c.Discovery, newCfg.Discovery)
+		c.Discovery = newCfg.Discovery
+		ret++
+	}
+	if c.Federation.MaxEvidence != newCfg.Federation.MaxEvidence {
+		logChange("federation.maxEvidence", c.Federation.MaxEvidence, newCfg.Federation.MaxEvidence)
+		c.Federation.MaxEvidence = newCfg.Federation.MaxEvidence
+		ret++
+	}
+	if c.InputSilenceTimeoutSec != newCfg.InputSilenceTimeoutSec {
+		logChange("inputSilenceTimeoutSec", c.InputSilenceTimeoutSec, newCfg.InputSilenceTimeoutSec)
+		c.InputSilenceTimeoutSec = newCfg.InputSilenceTimeoutSec
+		ret++
+	}
+	if !reflect.DeepEqual(c.Reconnect, newCfg.Reconnect) {
+		logChange("reconnect", c.Reconnect, newCfg.Reconnect)
+		c.Reconnect = newCfg.Reconnect
+		setReconnectParams(newCfg.Reconnect)
+		ret++
+	}
+	if c.SnapshotFile != newCfg.SnapshotFile {
+		logChange("snapshotFile", c.SnapshotFile, newCfg.SnapshotFile)
+		c.SnapshotFile = newCfg.SnapshotFile
+		ret++
+	}
+	if c.SnapshotPeriodMin != newCfg.SnapshotPeriodMin {
+		logChange("snapshotPeriodMin", c.SnapshotPeriodMin, newCfg.SnapshotPeriodMin)
+		c.SnapshotPeriodMin = newCfg.SnapshotPeriodMin
+		ret++
+	}
+	if c.RetentionPeriodMin != newCfg.RetentionPeriodMin {
+		logChange("retentionPeriodMin", c.RetentionPeriodMin, newCfg.RetentionPeriodMin)
+		c.RetentionPeriodMin = newCfg.RetentionPeriodMin
+		inputpart.SetRetentionPeriodMin(newCfg.RetentionPeriodMin)
+		ret++
+	}
+	if c.IriMsgStream.OutputEnabled != newCfg.IriMsgStream.OutputEnabled {
+		if err := inputpart.SetOutputEnabled(newCfg.IriMsgStream.OutputEnabled); err != nil {
+			errorf("Config reload: failed to change 'iriMsgStream.outputEnabled': %v", err)
+		} else {
+			logChange("iriMsgStream.outputEnabled", c.IriMsgStream.OutputEnabled, newCfg.IriMsgStream.OutputEnabled)
+			c.IriMsgStream.OutputEnabled = newCfg.IriMsgStream.OutputEnabled
+			ret++
+		}
+	}
+	if c.IriMsgStream.OutputWSEnabled != newCfg.IriMsgStream.OutputWSEnabled {
+		logChange("iriMsgStream.outputWSEnabled", c.IriMsgStream.OutputWSEnabled, newCfg.IriMsgStream.OutputWSEnabled)
+		c.IriMsgStream.OutputWSEnabled = newCfg.IriMsgStream.OutputWSEnabled
+		ret++
+	}
+	if c.IriMsgStream.OutputWSMaxClients != newCfg.IriMsgStream.OutputWSMaxClients {
+		logChange("iriMsgStream.outputWSMaxClients", c.IriMsgStream.OutputWSMaxClients, newCfg.IriMsgStream.OutputWSMaxClients)
+		c.IriMsgStream.OutputWSMaxClients = newCfg.IriMsgStream.OutputWSMaxClients
+		ret++
+	}
+	c.IriMsgStream.InputsZMQ = newCfg.IriMsgStream.InputsZMQ
+	c.IriMsgStream.InputsNanomsg = newCfg.IriMsgStream.InputsNanomsg
+	c.SpawnCmd = newCfg.SpawnCmd
+	cfg.Set(c)
+
+	// new inputs are created with weights of the new config
+	ret += applyInputChanges(oldCfg, newCfg)
+	ret += applySpawnCmdChanges(oldCfg, newCfg)
+	return ret
+}
+
+func applyInputChanges(oldCfg, newCfg *cfg.ConfigStructYAML) int {
+	oldZMQ, oldNanomsg := getEffectiveInputs(oldCfg)
+	newZMQ, newNanomsg := getEffectiveInputs(newCfg)
+
+	var ret int
+	// removing first, uri may move from ZMQ to Nanomsg list
+	for _, uri := range oldZMQ {
+		if !contains(newZMQ, uri) {
+			ret += reloadRemoveInput(uri)
+		}
+	}
+	for _, uri := range oldNanomsg {
+		if !contains(newNanomsg, uri) {
+			ret += reloadRemoveInput(uri)
+		}
+	}
+	for _, uri := range newZMQ {
+		if !contains(oldZMQ, uri) {
+			ret += reloadAddInput(uri, "zmq")
+		}
+	}
+	for _, uri := range newNanomsg {
+		if !contains(oldNanomsg, uri) {
+			ret += reloadAddInput(uri, "nanomsg")
+		}
+	}
+	return ret
+}
+
+func reloadRemoveInput(uri string) int {
+	if err := inputpart.RemoveInput(uri); err != nil {
+		errorf("Config reload: failed to remove input '%v': %v", uri, err)
+		return 0
+	}
+	infof("Config reload: input '%v' removed", uri)
+	return 1
+}
+
+func reloadAddInput(uri string, protocol string) int {
+	if err := inputpart.AddInput(uri, protocol); err != nil {
+		errorf("Config reload: failed to add %v input '%v': %v", protocol, uri, err)
+		return 0
+	}
+	infof("Config reload: %v input '%v' added", protocol, uri)
+	if isPausedInOverlay(uri) {
+		if err := inputpart.PauseInput(uri); err != nil {
+			errorf("Config reload: %v", err)
+		}
+	}
+	return 1
+}
+
+func applySpawnCmdChanges(oldCfg, newCfg *cfg.ConfigStructYAML) int {
+	var ret int
+	for _, cmdline := range oldCfg.SpawnCmd {
+		if !contains(newCfg.SpawnCmd, cmdline) {
+			infof("Config reload: command '%v' removed", cmdline)
+			killCmd(cmdline)
+			ret++
+		}
+	}
+	for _, cmdline := range newCfg.SpawnCmd {
+		if !contains(oldCfg.SpawnCmd, cmdline) {
+			infof("Config reload: command '%v' added", cmdline)
+			spawnCmd(cmdline)
+			ret++
+		}
+	}
+	return ret
+}
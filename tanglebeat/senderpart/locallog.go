@@ -33,7 +33,7 @@ func debugf(format string, args ...interface{}) {
 }
 
 func tracef(format string, args ...interface{}) {
-	if !cfg.Config.Debug {
+	if !cfg.Get().Debug {
 		return
 	}
 	if !localTrace {
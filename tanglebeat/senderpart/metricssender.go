@@ -11,6 +11,11 @@ var (
 	confDurationSecCounter       *prometheus.CounterVec
 	confPoWDurationSecCounter    *prometheus.CounterVec
 	confTipselDurationSecCounter *prometheus.CounterVec
+
+	// distributions, so quantiles can be calculated by Prometheus over any time window
+	confDurationHistogram   *prometheus.HistogramVec
+	powDurationHistogram    *prometheus.HistogramVec
+	tipselDurationHistogram *prometheus.HistogramVec
 	//restartCounter               prometheus.Counter
 )
 
@@ -40,6 +45,24 @@ func init() {
 		Help: "Sums up total duration it took to do tip selection for confirmation.",
 	}, []string{"seqid", "node_tipsel"})
 
+	confDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tanglebeat_confirmation_duration_seconds",
+		Help:    "Distribution of confirmation durations of the transfer",
+		Buckets: []float64{30, 60, 90, 120, 180, 240, 300, 420, 600, 900, 1200, 1800, 2700, 3600, 7200},
+	}, []string{"seqid", "strategy", "node_pow"})
+
+	powDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tanglebeat_pow_duration_seconds",
+		Help:    "Distribution of total duration of PoW per confirmed transfer",
+		Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 90, 120, 180, 300, 600},
+	}, []string{"seqid", "strategy", "node_pow"})
+
+	tipselDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tanglebeat_tipsel_duration_seconds",
+		Help:    "Distribution of total duration of tip selection per confirmed transfer",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"seqid", "strategy", "node_tipsel"})
+
 	//restartCounter = prometheus.NewCounter(prometheus.CounterOpts{
 	//	Name: "tanglebeat_restart_counter",
 	//	Help: "Increases every time program starts",
@@ -49,6 +72,9 @@ func init() {
 	prometheus.MustRegister(confPoWCostCounter)
 	prometheus.MustRegister(confPoWDurationSecCounter)
 	prometheus.MustRegister(confTipselDurationSecCounter)
+	prometheus.MustRegister(confDurationHistogram)
+	prometheus.MustRegister(powDurationHistogram)
+	prometheus.MustRegister(tipselDurationHistogram)
 	//prometheus.MustRegister(restartCounter)
 }
 
@@ -91,4 +117,29 @@ func updateSenderMetrics(upd *sender_update.SenderUpdate) {
 			"seqid":       upd.SeqUID,
 			"node_tipsel": upd.NodeTipsel,
 		}).Add(float64(upd.TotalTipselMsec) / 1000)
+
+	strategy := "blowball"
+	if upd.PromoteChain {
+		strategy = "chain"
+	}
+	confDurationHistogram.
+		With(prometheus.Labels{
+			"seqid":    upd.SeqUID,
+			"strategy": strategy,
+			"node_pow": upd.NodePOW,
+		}).Observe(durSec)
+
+	powDurationHistogram.
+		With(prometheus.Labels{
+			"seqid":    upd.SeqUID,
+			"strategy": strategy,
+			"node_pow": upd.NodePOW,
+		}).Observe(float64(upd.TotalPoWMsec) / 1000)
+
+	tipselDurationHistogram.
+		With(prometheus.Labels{
+			"seqid":       upd.SeqUID,
+			"strategy":    strategy,
+			"node_tipsel": upd.NodeTipsel,
+		}).Observe(float64(upd.TotalTipselMsec) / 1000)
 }
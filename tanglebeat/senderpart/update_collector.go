@@ -3,6 +3,8 @@ package senderpart
 import (
 	"fmt"
 	"github.com/unioproject/tanglebeat/lib/nanomsg"
+	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/ha"
 	"github.com/unioproject/tanglebeat/tanglebeat/hashcache"
 	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
 	"github.com/unioproject/tanglebeat/tanglebeat/inreaders"
@@ -30,14 +32,18 @@ var (
 	publishedUpdates    *hashcache.HashCacheBase
 )
 
-func MustInitSenderDataCollector(outEnabled bool, outPort int, inputs []string) {
+func MustInitSenderDataCollector(outEnabled bool, outPort int, outTLS bool, inputs []string) {
 	publishedUpdates = hashcache.NewHashCacheBase(
 		"publishedUpdates", 0, 10*60, 60*60)
 	senderUpdateSources = inreaders.NewInputReaderSet("sender update routine set")
 
 	if outEnabled {
 		var err error
-		senderOutPublisher, err = nanomsg.NewPublisher(outEnabled, outPort, 0, localLog)
+		var tlsCfg *nanomsg.TLSServerYAML
+		if outTLS {
+			tlsCfg = &cfg.Config.TLS.Server
+		}
+		senderOutPublisher, err = nanomsg.NewPublisherTLS(outEnabled, outPort, 0, tlsCfg, localLog)
 		if err != nil {
 			errorf("Failed to create sender output publishing channel: %v", err)
 			panic(err)
@@ -62,7 +68,7 @@ func (r *updateSource) Run(name string) inreaders.ReasonNotRunning {
 	infof("Starting sender update source '%v' at '%v'", name, uri)
 	defer errorf("Leaving sender update source '%v' at '%v'", name, uri)
 
-	chIn, err := sender_update.NewUpdateChan(uri)
+	chIn, err := sender_update.NewUpdateChan(uri, &cfg.Config.TLS.Client)
 	if err != nil {
 		errorf("failed to initialize sender update source for %v: %v", uri, err)
 		return inreaders.REASON_NORUN_ERROR
@@ -98,7 +104,7 @@ func (r *updateSource) processUpdate(upd *sender_update.SenderUpdate) error {
 		inputpart.TxSentForEcho(upd.PromoTail, upd.UpdateTs)
 	}
 
-	if senderOutPublisher != nil {
+	if senderOutPublisher != nil && ha.IsLeader() {
 		if upd.UpdType == sender_update.SENDER_UPD_CONFIRM {
 			debugf("Publish update '%v' received from %v, seq: %v(%v), Index: %v",
 				upd.UpdType, r.GetUri(), upd.SeqUID, upd.SeqName, upd.Index)
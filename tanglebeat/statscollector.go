@@ -5,10 +5,9 @@ import (
 	"fmt"
 	"github.com/unioproject/tanglebeat/lib/utils"
 	"github.com/unioproject/tanglebeat/tanglebeat/cfg"
+	"github.com/unioproject/tanglebeat/tanglebeat/ha"
 	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
 	"math"
-	"net"
-	"net/url"
 	"runtime"
 	"sync"
 	"time"
@@ -17,6 +16,8 @@ import (
 type GlbStats struct {
 	InstanceVersion     string                         `json:"instanceVersion"`
 	InstanceStarted     uint64                         `json:"instanceStarted"`
+	InstanceId          string                         `json:"instanceId"`
+	HARole              string                         `json:"haRole"`
 	QuorumTX            int                            `json:"quorumTX"`
 	QuorumSN            int                            `json:"quorumSN"`
 	QuorumLMI           int                            `json:"quorumLMI"`
@@ -25,6 +26,7 @@ type GlbStats struct {
 	ZmqOutputStats      inputpart.ZmqOutputStatsStruct `json:"zmqOutputStats"`
 	ZmqOutputStats10min inputpart.ZmqOutputStatsStruct `json:"zmqOutputStats10min"`
 	ZmqInputStats       []*inputpart.ZmqRoutineStats   `json:"zmqInputStats"`
+	ValveEvents         []*inputpart.ValveEvent        `json:"valveEvents"`
 
 	mutex *sync.RWMutex
 }
@@ -42,6 +44,7 @@ var glbStats = &GlbStats{
 }
 
 func initGlobStatsCollector(refreshEverySec int) {
+	glbStats.InstanceId = cfg.Config.Federation.InstanceId
 
 	inputpart.InitZmqStatsCollector(refreshEverySec)
 	go updateGlbStatsLoop(refreshEverySec)
@@ -53,10 +56,12 @@ func updateGlbStatsLoop(refreshStatsEverySec int) {
 		runtime.ReadMemStats(&mem)
 
 		inp := inputpart.GetInputStats()
+		updateInputMetrics(inp)
 
 		glbStats.mutex.Lock()
 
 		glbStats.ZmqInputStats = inp
+		glbStats.ValveEvents = inputpart.GetValveEvents()
 		glbStats.ZmqCacheStats = *inputpart.GetZmqCacheStats()
 		t1, t2 := inputpart.GetOutputStats()
 		glbStats.ZmqOutputStats, glbStats.ZmqOutputStats10min = *t1, *t2
@@ -66,6 +71,7 @@ func updateGlbStatsLoop(refreshStatsEverySec int) {
 
 		glbStats.GoRuntimeStats.NumGoroutine = runtime.NumGoroutine()
 
+		glbStats.HARole = ha.GetRole()
 		glbStats.QuorumTX = inputpart.GetTxQuorum()
 		glbStats.QuorumSN = inputpart.GetSnQuorum()
 		glbStats.QuorumLMI = inputpart.GetLmiQuorum()
@@ -104,14 +110,6 @@ func isActiveRoutine(r *inputpart.ZmqRoutineStats) bool {
 	return true
 }
 
-func isIpAddr(uri string) bool {
-	p, err := url.Parse(uri)
-	if err != nil {
-		return false
-	}
-	return net.ParseIP(p.Hostname()) != nil
-}
-
 func getMaskedGlbStats(maskIP bool, hideInactive bool) *GlbStats {
 	if !maskIP && !hideInactive {
 		return glbStats
@@ -120,7 +118,7 @@ func getMaskedGlbStats(maskIP bool, hideInactive bool) *GlbStats {
 	maskedInputs := make([]*inputpart.ZmqRoutineStats, 0, len(glbStats.ZmqInputStats))
 	for _, inp := range glbStats.ZmqInputStats {
 		if !hideInactive || isActiveRoutine(inp) {
-			if maskIP && isIpAddr(inp.Uri) {
+			if maskIP && utils.IsIpAddr(inp.Uri) {
 				tmp := *inp
 				tmp.Uri = "IP addr (masked)"
 				maskedInputs = append(maskedInputs, &tmp)
@@ -131,5 +129,16 @@ func getMaskedGlbStats(maskIP bool, hideInactive bool) *GlbStats {
 	}
 	ret := *glbStats
 	ret.ZmqInputStats = maskedInputs
+	if maskIP {
+		ret.ValveEvents = make([]*inputpart.ValveEvent, len(glbStats.ValveEvents))
+		for i, ev := range glbStats.ValveEvents {
+			ret.ValveEvents[i] = ev
+			if utils.IsIpAddr(ev.Uri) {
+				tmp := *ev
+				tmp.Uri = "IP addr (masked)"
+				ret.ValveEvents[i] = &tmp
+			}
+		}
+	}
 	return &ret
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
+	"net/http"
+)
+
+// GET /api1/admin/watch/list
+// POST /api1/admin/watch/add?name=<name>&address=<address>[&webhook=<url>]
+// POST /api1/admin/watch/remove?name=<name>[&address=<address>]
+func adminWatchHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAdminAuthorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	op := r.URL.Path[len("/api1/admin/watch/"):]
+	if op == "list" {
+		data, err := json.MarshalIndent(inputpart.GetWatchList(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST expected", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.FormValue("name")
+	address := r.FormValue("address")
+	var err error
+	switch op {
+	case "add":
+		err = inputpart.AddWatch(name, address, r.FormValue("webhook"))
+	case "remove":
+		err = inputpart.RemoveWatch(name, address)
+	default:
+		http.Error(w, fmt.Sprintf("wrong operation '%v'", op), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		errorf("Admin API: watch %v '%v' %v: %v", op, name, address, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	infof("Admin API: watch %v '%v' %v: ok", op, name, address)
+	_, _ = fmt.Fprintf(w, "ok\n")
+}
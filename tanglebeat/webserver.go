@@ -2,7 +2,8 @@ package main
 
 import (
 	"fmt"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/unioproject/tanglebeat/tanglebeat/ha"
+	"github.com/unioproject/tanglebeat/tanglebeat/inputpart"
 	"github.com/unioproject/tanglebeat/tanglebeat/senderpart"
 	"net/http"
 	"strings"
@@ -15,7 +16,14 @@ func runWebServer(port int) {
 	http.HandleFunc("/api1/internal_stats/", internalStatsHandler)
 	http.HandleFunc("/api1/conf_time", senderpart.HandlerConfStats)
 	http.HandleFunc("/api1/senders", senderpart.HandlerSenderStates)
-	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/api1/admin/inputs/", adminInputsHandler)
+	http.HandleFunc("/api1/inputs/health", inputsHealthHandler)
+	http.HandleFunc("/api1/admin/watch/", adminWatchHandler)
+	http.HandleFunc("/api1/admin/filters/", adminFiltersHandler)
+	http.HandleFunc("/api1/stream", inputpart.HandlerWSOutput)
+	http.HandleFunc("/api1/transfers", inputpart.HandlerTransfers)
+	http.HandleFunc("/api1/transfers/stream", inputpart.HandlerTransfersStream)
+	http.Handle("/metrics", ha.MetricsHandler())
 	panic(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
 }
 
@@ -9,6 +9,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/unioproject/tanglebeat/lib/config"
 	"github.com/unioproject/tanglebeat/lib/multiapi"
+	"github.com/unioproject/tanglebeat/lib/nanomsg"
 	"github.com/unioproject/tanglebeat/lib/utils"
 	"io"
 	"os"
@@ -96,8 +97,9 @@ type senderParamsYAML struct {
 }
 
 type senderUpdatePublisherYAML struct {
-	Enabled    bool `yaml:"enabled"`
-	OutputPort int  `yaml:"outputPort"`
+	Enabled    bool                   `yaml:"enabled"`
+	OutputPort int                    `yaml:"outputPort"`
+	TLS        *nanomsg.TLSServerYAML `yaml:"tls"` // publish on 'tls+tcp://' if set
 }
 
 // main config structure
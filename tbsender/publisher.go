@@ -8,8 +8,8 @@ var updatePublisher *nanomsg.Publisher
 
 func mustInitAndRunPublisher() {
 	var err error
-	updatePublisher, err = nanomsg.NewPublisher(
-		Config.SenderUpdatePublisher.Enabled, Config.SenderUpdatePublisher.OutputPort, 0, log)
+	updatePublisher, err = nanomsg.NewPublisherTLS(Config.SenderUpdatePublisher.Enabled,
+		Config.SenderUpdatePublisher.OutputPort, 0, Config.SenderUpdatePublisher.TLS, log)
 	if err != nil {
 		log.Errorf("Failed to create publishing channel: %v", err)
 		Config.SenderUpdatePublisher.Enabled = false
@@ -4,23 +4,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/unioproject/tanglebeat/lib/nanomsg"
 	"nanomsg.org/go-mangos"
 	"nanomsg.org/go-mangos/protocol/sub"
-	"nanomsg.org/go-mangos/transport/tcp"
 	"time"
 )
 
-// uri must be like "tcp://my.host:3100"
+// uri must be like "tcp://my.host:3100" or "tls+tcp://my.host:3100". TLS config is needed only for the latter
 
-func NewUpdateChan(uri string) (chan *SenderUpdate, error) {
+func NewUpdateChan(uri string, tlsCfg *nanomsg.TLSClientYAML) (chan *SenderUpdate, error) {
 	var sock mangos.Socket
 	var err error
 
 	if sock, err = sub.NewSocket(); err != nil {
 		return nil, errors.New(fmt.Sprintf("can't create new sub socket: %v", err))
 	}
-	sock.AddTransport(tcp.NewTransport())
-	if err = sock.Dial(uri); err != nil {
+	if err = nanomsg.Dial(sock, uri, tlsCfg); err != nil {
 		return nil, errors.New(fmt.Sprintf("can't dial sub socket at %v: %v", uri, err))
 	}
 	err = sock.SetOption(mangos.OptionSubscribe, []byte(""))
@@ -0,0 +1,149 @@
+package main
+
+import (
+	. "github.com/iotaledger/iota.go/trinary"
+	"github.com/op/go-logging"
+	"github.com/unioproject/tanglebeat/lib/mockiri"
+	"github.com/unioproject/tanglebeat/lib/utils"
+	"github.com/unioproject/tanglebeat/tbsender/bundle_source"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// tests of the transfer bundle generator ('traveling iota') against in-process IRI mock
+
+const (
+	seed4test    = "TANGLEBEAT9TEST9SEED9999999999999999999999999999999999999999999999999999999999999"
+	balance4test = 1000
+)
+
+func newGenerator4test(t *testing.T, m *mockiri.MockIRI) *transferBundleGenerator {
+	dir, err := ioutil.TempDir("", "tbsender_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Config.siteDataDir = dir
+	params := &senderParamsYAML{
+		Enabled:        true,
+		IOTANode:       []string{m.URL()},
+		IOTANodeTipsel: []string{m.URL()},
+		IOTANodePoW:    m.URL(),
+		TimeoutAPI:     10,
+		TimeoutTipsel:  10,
+		TimeoutPoW:     10,
+		Seed:           seed4test,
+		TxTag:          "TBTEST",
+	}
+	gen, err := initTransferBundleGenerator("test", params, logging.MustGetLogger("tbsender_test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gen
+}
+
+func mustGetAddress(t *testing.T, gen *transferBundleGenerator, index uint64) Hash {
+	ret, err := gen.getAddress(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func Test_TransferSendAndFind(t *testing.T) {
+	m := mockiri.New()
+	defer m.Close()
+	gen := newGenerator4test(t, m)
+	defer os.RemoveAll(Config.siteDataDir)
+
+	addr0 := mustGetAddress(t, gen, 0)
+	addr1 := mustGetAddress(t, gen, 1)
+	m.SetBalance(addr0, balance4test)
+
+	spent, balance, err := gen.CheckBalance(addr0)
+	if err != nil || spent || balance != balance4test {
+		t.Fatalf("CheckBalance returned spent = %v balance = %v err = %v", spent, balance, err)
+	}
+	bundleData, createNew, err := gen.findBundleToConfirm(addr0)
+	if err != nil || bundleData != nil || !createNew {
+		t.Fatalf("nothing must be found on fresh address, err = %v", err)
+	}
+
+	sent, err := gen.sendToNext(addr0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsSpent(addr0) || len(sent.BundleTrytes) != securityLevel+1 {
+		t.Errorf("expected spent address and bundle of %v transactions", securityLevel+1)
+	}
+	tail, err := utils.TailFromBundleTrytes(sent.BundleTrytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reattach the same bundle
+	gtta, _ := gen.iotaMultiAPIgTTA.GetTransactionsToApprove(3)
+	reattached, err := gen.iotaMultiAPIaTT.AttachToTangle(gtta.TrunkTransaction, gtta.BranchTransaction, uint64(14), sent.BundleTrytes)
+	if err == nil {
+		_, err = gen.iotaMultiAPI.StoreAndBroadcast(reattached)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundleData, createNew, err = gen.findBundleToConfirm(addr0)
+	if err != nil || bundleData == nil || createNew {
+		t.Fatalf("spending bundle must be found, err = %v", err)
+	}
+	found, err := utils.TailFromBundleTrytes(bundleData.BundleTrytes)
+	if err != nil || found.Bundle != tail.Bundle || bundleData.NumAttach != 2 {
+		t.Errorf("wrong bundle found: %v, num attach = %v, err = %v", found.Bundle, bundleData.NumAttach, err)
+	}
+
+	m.ConfirmBundle(tail.Bundle)
+	if _, balance, _ = gen.CheckBalance(addr1); balance != balance4test {
+		t.Errorf("balance must be moved to the next address")
+	}
+	bundleData, createNew, err = gen.findBundleToConfirm(addr0)
+	if err != nil || bundleData != nil || !createNew {
+		t.Errorf("confirmed bundle must not be returned, err = %v", err)
+	}
+}
+
+// runs generator loop and confirms each bundle it produces
+func Test_TransferTravelingIota(t *testing.T) {
+	m := mockiri.New()
+	defer m.Close()
+	gen := newGenerator4test(t, m)
+	defer os.RemoveAll(Config.siteDataDir)
+
+	m.SetBalance(mustGetAddress(t, gen, 0), balance4test)
+	go gen.runGenerator()
+
+	for i := uint64(0); i < 3; i++ {
+		chBundle := make(chan *bundle_source.FirstBundleData, 1)
+		go func() {
+			chBundle <- gen.bundleSource.GetNextBundleToConfirm()
+		}()
+		select {
+		case bd := <-chBundle:
+			if bd.Index != i || !m.ConfirmBundle(bd.BundleHash) {
+				t.Fatalf("bundle %v with index %v is not on the tangle", bd.BundleHash, bd.Index)
+			}
+			gen.bundleSource.PutConfirmationResult(bd.BundleHash, true)
+		case <-time.After(30 * time.Second):
+			t.Fatalf("no bundle produced for index %v", i)
+		}
+	}
+	// wait until generator moves to the next index
+	time.Sleep(1 * time.Second)
+	if balance := m.GetBalance(mustGetAddress(t, gen, 3)); balance != balance4test {
+		t.Errorf("balance %v expected at index 3, got %v", balance4test, balance)
+	}
+	for i := uint64(0); i < 3; i++ {
+		if !m.IsSpent(mustGetAddress(t, gen, i)) || m.GetBalance(mustGetAddress(t, gen, i)) != 0 {
+			t.Errorf("address with index %v must be spent and empty", i)
+		}
+	}
+}
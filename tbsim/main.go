@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/op/go-logging"
+	"github.com/unioproject/tanglebeat/lib/irisim"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// program simulates number of IRI nodes publishing 'tx', 'sn', 'lmi' and 'lmhs' messages
+// in IRI ZMQ format. It is used to run Tanglebeat locally without public nodes.
+// Node i listens on port <port>+i
+// usage: tbsim [-nodes <N>] [-proto zmq|nanomsg] [-port <base port>] [-tps <TPS>] ...
+// Lags and drop rates are comma separated lists, one value per node. Last value applies to the rest of nodes
+
+func main() {
+	initLogging()
+
+	pNodes := flag.Int("nodes", 5, "number of simulated nodes")
+	pProto := flag.String("proto", "zmq", "protocol of output sockets: zmq or nanomsg")
+	pPort := flag.Int("port", 5556, "port of the first node. Node i listens on port+i")
+	pTps := flag.Float64("tps", 10, "transactions per second")
+	pConf := flag.Float64("conf", 0.7, "share of bundles confirmed by next milestone, 0 to 1")
+	pValue := flag.Float64("value", 0.05, "share of value bundles, 0 to 1")
+	pMsInt := flag.Float64("msint", 60, "milestone interval in seconds")
+	pMsIdx := flag.Int("msidx", 1000000, "index of the first milestone")
+	pLag := flag.String("lag", "0", "lag of nodes in milliseconds, comma separated")
+	pJitter := flag.Int("jitter", 50, "max random delay of each message in milliseconds")
+	pDrop := flag.String("drop", "0", "probability of message to be lost by the node, comma separated")
+	pOutOfSync := flag.Int("outofsync", 0, "number of nodes (last ones) which are out of sync")
+	pOutOfSyncMs := flag.Int("outofsyncms", 3, "number of milestones out of sync nodes are behind")
+	pSeed := flag.Int64("seed", 0, "seed of random generator. 0 means time based")
+	flag.Parse()
+
+	lags, err := parseList(*pLag, *pNodes)
+	if err != nil {
+		criticalf("wrong -lag: %v", err)
+		os.Exit(1)
+	}
+	drops, err := parseList(*pDrop, *pNodes)
+	if err != nil {
+		criticalf("wrong -drop: %v", err)
+		os.Exit(1)
+	}
+	params := irisim.Params{
+		Protocol:             *pProto,
+		BasePort:             *pPort,
+		Nodes:                make([]irisim.NodeParams, *pNodes),
+		TPS:                  *pTps,
+		ConfirmationRate:     *pConf,
+		ValueBundleRate:      *pValue,
+		MilestoneIntervalSec: *pMsInt,
+		StartMilestoneIndex:  *pMsIdx,
+		Seed:                 *pSeed,
+	}
+	for i := range params.Nodes {
+		params.Nodes[i] = irisim.NodeParams{
+			LagMs:    int(lags[i]),
+			JitterMs: *pJitter,
+			DropRate: drops[i],
+		}
+		if i >= *pNodes-*pOutOfSync {
+			params.Nodes[i].OutOfSyncMilestones = *pOutOfSyncMs
+		}
+	}
+	irisim.SetLog(log)
+	sim, err := irisim.NewSimulator(params)
+	if err != nil {
+		criticalf("%v", err)
+		os.Exit(1)
+	}
+	for i, uri := range sim.URIs() {
+		infof("Node #%v: %v %+v", i, uri, params.Nodes[i])
+	}
+	sim.Start()
+
+	chSig := make(chan os.Signal, 1)
+	signal.Notify(chSig, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(10 * time.Second)
+	for {
+		select {
+		case <-chSig:
+			sim.Stop()
+			infof("Ciao")
+			os.Exit(0)
+		case <-ticker.C:
+			st := sim.GetStats()
+			infof("Milestone %v, tx generated: %v, bundles: %v (value bundles: %v), tx confirmed: %v",
+				st.MilestoneIndex, st.NumTx, st.NumBundles, st.NumValueBundles, st.NumConfirmedTx)
+			for i, n := range st.Nodes {
+				infof("    node #%v %v: sent %v, dropped %v, queued %v", i, n.Uri, n.Sent, n.Dropped, n.Queued)
+			}
+		}
+	}
+}
+
+// parses comma separated list into n values. Last value is repeated if list is shorter
+func parseList(s string, n int) ([]float64, error) {
+	ret := make([]float64, 0, n)
+	for _, v := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%v': %v", s, err)
+		}
+		ret = append(ret, f)
+	}
+	for len(ret) < n {
+		ret = append(ret, ret[len(ret)-1])
+	}
+	return ret[:n], nil
+}
+
+// logging
+
+const (
+	logFormat = "%{time:2006-01-02 15:04:05.000} %{level:.4s} [%{module}] %{message}"
+	logLevel  = logging.INFO
+)
+
+var log *logging.Logger
+
+func initLogging() {
+	log = logging.MustGetLogger("tbsim")
+	backend := logging.NewLogBackend(os.Stderr, "", 0)
+	logFormat := logging.MustStringFormatter(logFormat)
+	backendFormatter := logging.NewBackendFormatter(backend, logFormat)
+	backendLeveled := logging.AddModuleLevel(backendFormatter)
+	backendLeveled.SetLevel(logLevel, "tbsim")
+	log.SetBackend(backendLeveled)
+}
+
+func infof(format string, args ...interface{}) {
+	log.Infof(format, args...)
+}
+
+func criticalf(format string, args ...interface{}) {
+	log.Criticalf(format, args...)
+}